@@ -0,0 +1,96 @@
+// Package alert delivers alerts about bot problems to sinks like a Telegram
+// chat, a webhook or stdout, and throttles them so one broken task can't flood
+// the sinks.
+package alert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+const webhookTimeout = 5 * time.Second
+
+// Alert is one alert about a problem.
+type Alert struct {
+	// Key groups identical alerts, it must not contain details which differ
+	// between occurrences of one problem, like user IDs.
+	Key string
+	// Text is the full text of the alert.
+	Text string
+}
+
+// Alerter delivers alerts. Implementations must be safe for concurrent use.
+type Alerter interface {
+	Alert(alert Alert)
+}
+
+// Multi delivers alerts to all of its alerters.
+type Multi []Alerter
+
+func (m Multi) Alert(alert Alert) {
+	for _, alerter := range m {
+		alerter.Alert(alert)
+	}
+}
+
+// Writer writes alerts as lines, e.g. to stdout.
+type Writer struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out}
+}
+
+func (w *Writer) Alert(alert Alert) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, _ = fmt.Fprintf(w.out, "ALERT %s %s\n", time.Now().UTC().Format(time.RFC3339), alert.Text)
+}
+
+// Webhook posts alerts as JSON objects with the text field, which is accepted
+// by Slack and Mattermost incoming webhooks.
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+func (w *Webhook) Alert(alert Alert) {
+	body, _ := json.Marshal(map[string]string{"text": alert.Text})
+	response, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logging.Error("Error on posting alert to webhook", logging.Err(err))
+		return
+	}
+	_ = response.Body.Close()
+	if response.StatusCode >= 300 {
+		logging.Error("Error on posting alert to webhook", logging.Int("status", response.StatusCode))
+	}
+}
+
+// WithPrefix adds the prefix to texts of alerts, e.g. the host name.
+func WithPrefix(prefix string, next Alerter) Alerter {
+	return prefixed{prefix: prefix, next: next}
+}
+
+type prefixed struct {
+	prefix string
+	next   Alerter
+}
+
+func (p prefixed) Alert(alert Alert) {
+	alert.Text = p.prefix + alert.Text
+	p.next.Alert(alert)
+}
@@ -0,0 +1,217 @@
+package alert
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+const (
+	maxSummaryKeys = 10
+	// queueSize bounds alerts waiting for delivery, alerts over it are dropped.
+	queueSize = 100
+)
+
+// ThrottleConfig limits alerts within fixed windows.
+type ThrottleConfig struct {
+	// Window is the period within which identical alerts are sent once and at
+	// most Limit alerts are sent in total.
+	Window time.Duration
+	// Limit is the max number of alerts sent within a window, not counting the
+	// summary of suppressed ones.
+	Limit int
+}
+
+var DefaultThrottleConfig = ThrottleConfig{Window: time.Minute, Limit: 10}
+
+// Throttler passes alerts to the next alerter with deduplication and a rate
+// limit. Suppressed alerts are counted and reported by a summary at the end of
+// the window. After Start alerts are delivered by a goroutine, so callers never
+// wait for slow sinks.
+type Throttler struct {
+	next   Alerter
+	config ThrottleConfig
+	now    func() time.Time
+
+	mutex       sync.Mutex
+	windowStart time.Time
+	sentCount   int
+	sentKeys    map[string]struct{}
+	suppressed  map[string]int
+	queue       chan Alert
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func NewThrottler(next Alerter, config ThrottleConfig) *Throttler {
+	t := &Throttler{
+		next:   next,
+		config: config,
+		now:    time.Now,
+	}
+	t.resetWindow(t.now())
+	return t
+}
+
+func (t *Throttler) Alert(alert Alert) {
+	summary, send := t.admit(alert)
+	if summary != nil {
+		t.deliver(*summary)
+	}
+	if send {
+		t.deliver(alert)
+	}
+}
+
+// Start starts delivering alerts from the queue and sending summaries of
+// suppressed alerts at the end of every window even if there are no new alerts.
+// It must be stopped by Close.
+func (t *Throttler) Start() {
+	t.mutex.Lock()
+	t.queue = make(chan Alert, queueSize)
+	queue := t.queue
+	t.mutex.Unlock()
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		for alert := range queue {
+			t.next.Alert(alert)
+		}
+	}()
+	go func() {
+		defer close(t.done)
+		defer func() { <-delivered }()
+		ticker := time.NewTicker(t.config.Window)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+				t.Flush()
+			}
+		}
+	}()
+}
+
+// Close sends the last summary, delivers queued alerts and stops. Alerts after
+// Close are delivered synchronously.
+func (t *Throttler) Close() {
+	t.mutex.Lock()
+	summary := t.rotate(t.now())
+	if summary != nil {
+		t.enqueue(*summary)
+	}
+	queue := t.queue
+	t.queue = nil
+	t.mutex.Unlock()
+	if queue == nil {
+		if summary != nil {
+			t.next.Alert(*summary)
+		}
+		return
+	}
+	close(queue)
+	close(t.stop)
+	<-t.done
+}
+
+// Flush sends the summary if the current window is over.
+func (t *Throttler) Flush() {
+	t.mutex.Lock()
+	summary := t.rotateIfExpired(t.now())
+	t.mutex.Unlock()
+	if summary != nil {
+		t.deliver(*summary)
+	}
+}
+
+// deliver queues the alert or, if the throttler is not started, sends it.
+func (t *Throttler) deliver(alert Alert) {
+	t.mutex.Lock()
+	if t.queue != nil {
+		t.enqueue(alert)
+		t.mutex.Unlock()
+		return
+	}
+	t.mutex.Unlock()
+	t.next.Alert(alert)
+}
+
+// enqueue must be called with the mutex locked and the queue started.
+func (t *Throttler) enqueue(alert Alert) {
+	select {
+	case t.queue <- alert:
+	default:
+		logging.Error("Alert queue is full, alert is dropped", logging.String("key", alert.Key))
+	}
+}
+
+func (t *Throttler) admit(alert Alert) (*Alert, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	summary := t.rotateIfExpired(t.now())
+	if _, found := t.sentKeys[alert.Key]; found || t.sentCount >= t.config.Limit {
+		t.suppressed[alert.Key]++
+		return summary, false
+	}
+	t.sentKeys[alert.Key] = struct{}{}
+	t.sentCount++
+	return summary, true
+}
+
+func (t *Throttler) rotateIfExpired(now time.Time) *Alert {
+	if now.Sub(t.windowStart) < t.config.Window {
+		return nil
+	}
+	return t.rotate(now)
+}
+
+// rotate starts a new window and returns the summary of the previous one if
+// any alert was suppressed.
+func (t *Throttler) rotate(now time.Time) *Alert {
+	suppressed := t.suppressed
+	t.resetWindow(now)
+	if len(suppressed) == 0 {
+		return nil
+	}
+	return makeSummary(suppressed, t.config.Window)
+}
+
+func (t *Throttler) resetWindow(now time.Time) {
+	t.windowStart = now
+	t.sentCount = 0
+	t.sentKeys = make(map[string]struct{})
+	t.suppressed = make(map[string]int)
+}
+
+func makeSummary(suppressed map[string]int, window time.Duration) *Alert {
+	keys := make([]string, 0, len(suppressed))
+	total := 0
+	for key, count := range suppressed {
+		keys = append(keys, key)
+		total += count
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if suppressed[keys[i]] != suppressed[keys[j]] {
+			return suppressed[keys[i]] > suppressed[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	lines := []string{fmt.Sprintf("Suppressed %d alerts within %s:", total, window)}
+	for i, key := range keys {
+		if i == maxSummaryKeys {
+			lines = append(lines, fmt.Sprintf("and %d more kinds", len(keys)-maxSummaryKeys))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("%d × %s", suppressed[key], key))
+	}
+	return &Alert{Key: "summary", Text: strings.Join(lines, "\n")}
+}
@@ -0,0 +1,128 @@
+package alert
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mutex  sync.Mutex
+	alerts []Alert
+}
+
+func (r *recorder) Alert(alert Alert) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.alerts = append(r.alerts, alert)
+}
+
+func (r *recorder) take() []Alert {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	alerts := r.alerts
+	r.alerts = nil
+	return alerts
+}
+
+func newTestThrottler(config ThrottleConfig) (*Throttler, *recorder, *time.Time) {
+	sink := &recorder{}
+	now := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	throttler := NewThrottler(sink, config)
+	throttler.now = func() time.Time { return now }
+	throttler.resetWindow(now)
+	return throttler, sink, &now
+}
+
+func TestThrottlerGroupsIdenticalAlerts(t *testing.T) {
+	throttler, sink, now := newTestThrottler(ThrottleConfig{Window: time.Minute, Limit: 10})
+	for i := 0; i < 5; i++ {
+		throttler.Alert(Alert{Key: "Error on sending: Bad Request", Text: "Error on sending (userId=1)"})
+	}
+	throttler.Alert(Alert{Key: "Error on loading user", Text: "Error on loading user (userId=1)"})
+	if alerts := sink.take(); len(alerts) != 2 {
+		t.Fatalf("expected one alert of each kind, got %v", alerts)
+	}
+
+	*now = now.Add(time.Minute)
+	throttler.Flush()
+	alerts := sink.take()
+	if len(alerts) != 1 || !strings.Contains(alerts[0].Text, "Suppressed 4 alerts") || !strings.Contains(alerts[0].Text, "4 × Error on sending: Bad Request") {
+		t.Fatalf("expected summary of suppressed alerts, got %v", alerts)
+	}
+
+	throttler.Alert(Alert{Key: "Error on sending: Bad Request"})
+	if alerts := sink.take(); len(alerts) != 1 {
+		t.Errorf("expected alert to be sent again in a new window, got %v", alerts)
+	}
+	throttler.Flush()
+	if alerts := sink.take(); len(alerts) != 0 {
+		t.Errorf("expected no summary within the window, got %v", alerts)
+	}
+}
+
+func TestThrottlerLimit(t *testing.T) {
+	throttler, sink, now := newTestThrottler(ThrottleConfig{Window: time.Minute, Limit: 2})
+	for _, key := range []string{"a", "b", "c", "d"} {
+		throttler.Alert(Alert{Key: key, Text: key})
+	}
+	if alerts := sink.take(); len(alerts) != 2 {
+		t.Fatalf("expected alerts over the limit to be suppressed, got %v", alerts)
+	}
+
+	*now = now.Add(time.Minute)
+	throttler.Alert(Alert{Key: "e", Text: "e"})
+	alerts := sink.take()
+	if len(alerts) != 2 || alerts[0].Key != "summary" || alerts[1].Key != "e" {
+		t.Fatalf("expected summary of the previous window before the new alert, got %v", alerts)
+	}
+	if !strings.Contains(alerts[0].Text, "1 × c\n1 × d") {
+		t.Errorf("unexpected summary %q", alerts[0].Text)
+	}
+}
+
+func TestThrottlerClose(t *testing.T) {
+	throttler, sink, _ := newTestThrottler(ThrottleConfig{Window: time.Hour, Limit: 1})
+	throttler.Start()
+	throttler.Alert(Alert{Key: "a"})
+	throttler.Alert(Alert{Key: "a"})
+	throttler.Close()
+	alerts := sink.take()
+	if len(alerts) != 2 || alerts[1].Key != "summary" {
+		t.Fatalf("expected summary on close, got %v", alerts)
+	}
+}
+
+func TestWithPrefix(t *testing.T) {
+	sink := &recorder{}
+	WithPrefix("[host] ", Multi{sink, sink}).Alert(Alert{Key: "a", Text: "text"})
+	alerts := sink.take()
+	if len(alerts) != 2 || alerts[0].Text != "[host] text" || alerts[0].Key != "a" {
+		t.Errorf("unexpected alerts %v", alerts)
+	}
+}
+
+type blockingSink struct {
+	recorder
+	release chan struct{}
+}
+
+func (s *blockingSink) Alert(alert Alert) {
+	<-s.release
+	s.recorder.Alert(alert)
+}
+
+func TestThrottlerDoesNotBlockCallers(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	throttler := NewThrottler(sink, ThrottleConfig{Window: time.Hour, Limit: 10})
+	throttler.Start()
+	for _, key := range []string{"a", "b", "c"} {
+		throttler.Alert(Alert{Key: key})
+	}
+	close(sink.release)
+	throttler.Close()
+	if alerts := sink.take(); len(alerts) != 3 {
+		t.Fatalf("expected queued alerts to be delivered on close, got %v", alerts)
+	}
+}
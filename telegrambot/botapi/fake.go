@@ -0,0 +1,335 @@
+package botapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxUpdatesTimeout limits long polling of getUpdates, so a stopped bot does not
+// keep a request to the fake server for long.
+const maxUpdatesTimeout = 5 * time.Second
+
+const maxUploadMemory = 1 << 20
+
+// FakeServer is a fake Bot API which checks sent messages and polls against
+// Bot API constraints and answers like Telegram does, without sending anything.
+// Updates pushed by tests are served by getUpdates, and all other calls are
+// recorded to be asserted on.
+type FakeServer struct {
+	mutex         sync.Mutex
+	nextMessageID int
+	updates       []tgbotapi.Update
+	updatesPushed chan struct{}
+	calls         []Call
+	callsMade     chan struct{}
+	closed        chan struct{}
+}
+
+// Call is a request made to the fake server.
+type Call struct {
+	Method string
+	Params url.Values
+}
+
+func NewFakeServer() *FakeServer {
+	return &FakeServer{
+		nextMessageID: 1,
+		updatesPushed: make(chan struct{}),
+		callsMade:     make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+}
+
+// Close releases pending getUpdates requests. It must be called before closing
+// an HTTP server serving the fake.
+func (s *FakeServer) Close() {
+	close(s.closed)
+}
+
+// PushUpdate adds an update to be received by the bot and returns its ID.
+func (s *FakeServer) PushUpdate(update tgbotapi.Update) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	update.UpdateID = len(s.updates) + 1
+	s.updates = append(s.updates, update)
+	close(s.updatesPushed)
+	s.updatesPushed = make(chan struct{})
+	return update.UpdateID
+}
+
+// Calls returns all calls made so far, except getUpdates.
+func (s *FakeServer) Calls() []Call {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Call(nil), s.calls...)
+}
+
+// WaitForCalls waits until at least count calls are made and returns all of them.
+// Returns false if it does not happen within the timeout.
+func (s *FakeServer) WaitForCalls(count int, timeout time.Duration) ([]Call, bool) {
+	deadline := time.After(timeout)
+	for {
+		s.mutex.Lock()
+		calls := append([]Call(nil), s.calls...)
+		callsMade := s.callsMade
+		s.mutex.Unlock()
+		if len(calls) >= count {
+			return calls, true
+		}
+		select {
+		case <-callsMade:
+		case <-deadline:
+			return calls, false
+		}
+	}
+}
+
+// Client returns an HTTP client which serves every request by the fake server
+// in process, whatever host it is sent to.
+func (s *FakeServer) Client() *http.Client {
+	return &http.Client{Transport: fakeTransport{s}}
+}
+
+func (s *FakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(maxUploadMemory)
+	} else {
+		err = r.ParseForm()
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	if method == "getUpdates" {
+		writeResult(w, s.waitForUpdates(r))
+		return
+	}
+	s.recordCall(Call{Method: method, Params: r.Form})
+
+	var result interface{}
+	switch method {
+	case "getMe":
+		result = map[string]interface{}{"id": 1, "is_bot": true, "first_name": "Fake", "username": "FakeBot"}
+	case "sendMessage":
+		if err = checkSendMessage(r.Form); err == nil {
+			result = s.makeMessage(r.Form, nil)
+		}
+	case "sendPoll":
+		var options []string
+		if options, err = checkSendPoll(r.Form); err == nil {
+			result = s.makeMessage(r.Form, options)
+		}
+	case "editMessageText":
+		if err = checkEditMessageText(r.Form); err == nil {
+			result = editedMessage(r.Form)
+		}
+	case "answerCallbackQuery":
+		if length := TextLength(r.Form.Get("text")); length > MaxCallbackAnswerLength {
+			err = fmt.Errorf("MESSAGE_TOO_LONG: %d characters, max is %d", length, MaxCallbackAnswerLength)
+		} else {
+			result = true
+		}
+	default:
+		result = true
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("Bad Request: %s", err))
+		return
+	}
+	writeResult(w, result)
+}
+
+func (s *FakeServer) recordCall(call Call) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.calls = append(s.calls, call)
+	close(s.callsMade)
+	s.callsMade = make(chan struct{})
+}
+
+// waitForUpdates long polls updates starting from the offset like Bot API does.
+func (s *FakeServer) waitForUpdates(r *http.Request) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(r.Form.Get("offset"))
+	seconds, _ := strconv.Atoi(r.Form.Get("timeout"))
+	timeout := time.Duration(seconds) * time.Second
+	if timeout > maxUpdatesTimeout {
+		timeout = maxUpdatesTimeout
+	}
+	deadline := time.After(timeout)
+	for {
+		s.mutex.Lock()
+		updates := make([]tgbotapi.Update, 0)
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				updates = append(updates, update)
+			}
+		}
+		updatesPushed := s.updatesPushed
+		s.mutex.Unlock()
+		if len(updates) > 0 {
+			return updates
+		}
+		select {
+		case <-updatesPushed:
+		case <-deadline:
+			return updates
+		case <-s.closed:
+			return updates
+		case <-r.Context().Done():
+			return updates
+		}
+	}
+}
+
+func (s *FakeServer) makeMessage(form url.Values, pollOptions []string) map[string]interface{} {
+	s.mutex.Lock()
+	messageID := s.nextMessageID
+	s.nextMessageID++
+	s.mutex.Unlock()
+
+	chatID, _ := strconv.ParseInt(form.Get("chat_id"), 10, 64)
+	message := map[string]interface{}{
+		"message_id": messageID,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]interface{}{"id": chatID},
+		"text":       form.Get("text"),
+	}
+	if pollOptions != nil {
+		options := make([]map[string]interface{}, 0, len(pollOptions))
+		for _, option := range pollOptions {
+			options = append(options, map[string]interface{}{"text": option, "voter_count": 0})
+		}
+		message["poll"] = map[string]interface{}{
+			"id":       strconv.Itoa(messageID),
+			"question": form.Get("question"),
+			"options":  options,
+			"type":     form.Get("type"),
+		}
+	}
+	return message
+}
+
+func checkSendMessage(form url.Values) error {
+	text := form.Get("text")
+	if text == "" {
+		return fmt.Errorf("message text is empty")
+	}
+	length := TextLength(text)
+	if form.Get("parse_mode") == "HTML" {
+		if err := CheckHTML(text); err != nil {
+			return fmt.Errorf("can't parse entities: %v", err)
+		}
+		length = HTMLTextLength(text)
+	}
+	if length > MaxMessageLength {
+		return fmt.Errorf("message is too long: %d characters, max is %d", length, MaxMessageLength)
+	}
+	return checkReplyMarkup(form.Get("reply_markup"))
+}
+
+func checkEditMessageText(form url.Values) error {
+	if form.Get("message_id") == "" && form.Get("inline_message_id") == "" {
+		return fmt.Errorf("message to edit not found")
+	}
+	return checkSendMessage(form)
+}
+
+func editedMessage(form url.Values) map[string]interface{} {
+	chatID, _ := strconv.ParseInt(form.Get("chat_id"), 10, 64)
+	messageID, _ := strconv.Atoi(form.Get("message_id"))
+	return map[string]interface{}{
+		"message_id": messageID,
+		"date":       time.Now().Unix(),
+		"chat":       map[string]interface{}{"id": chatID},
+		"text":       form.Get("text"),
+	}
+}
+
+func checkSendPoll(form url.Values) ([]string, error) {
+	if length := TextLength(form.Get("question")); length == 0 || length > MaxPollQuestionLength {
+		return nil, fmt.Errorf("poll question length %d must be between 1 and %d", length, MaxPollQuestionLength)
+	}
+	var options []string
+	if err := json.Unmarshal([]byte(form.Get("options")), &options); err != nil {
+		return nil, fmt.Errorf("can't parse options: %v", err)
+	}
+	if len(options) < MinPollOptionsCount || len(options) > MaxPollOptionsCount {
+		return nil, fmt.Errorf("poll must have %d-%d options, got %d", MinPollOptionsCount, MaxPollOptionsCount, len(options))
+	}
+	for i, option := range options {
+		if length := TextLength(option); length == 0 || length > MaxPollOptionLength {
+			return nil, fmt.Errorf("poll option %d length %d must be between 1 and %d", i, length, MaxPollOptionLength)
+		}
+	}
+	if form.Get("type") == "quiz" {
+		correctOptionID, err := strconv.Atoi(form.Get("correct_option_id"))
+		if err != nil || correctOptionID < 0 || correctOptionID >= len(options) {
+			return nil, fmt.Errorf("wrong correct option id %q", form.Get("correct_option_id"))
+		}
+	}
+	if length := TextLength(form.Get("explanation")); length > MaxPollExplanationLength {
+		return nil, fmt.Errorf("poll explanation is too long: %d characters, max is %d", length, MaxPollExplanationLength)
+	}
+	return options, checkReplyMarkup(form.Get("reply_markup"))
+}
+
+func checkReplyMarkup(replyMarkup string) error {
+	if replyMarkup == "" {
+		return nil
+	}
+	var markup struct {
+		InlineKeyboard [][]struct {
+			Text         string  `json:"text"`
+			CallbackData *string `json:"callback_data"`
+		} `json:"inline_keyboard"`
+	}
+	if err := json.Unmarshal([]byte(replyMarkup), &markup); err != nil {
+		return fmt.Errorf("can't parse reply keyboard markup: %v", err)
+	}
+	for _, row := range markup.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == "" {
+				return fmt.Errorf("inline keyboard button text is empty")
+			}
+			if button.CallbackData != nil && (len(*button.CallbackData) == 0 || len(*button.CallbackData) > MaxCallbackDataLength) {
+				return fmt.Errorf("BUTTON_DATA_INVALID: %d bytes, max is %d", len(*button.CallbackData), MaxCallbackDataLength)
+			}
+		}
+	}
+	return nil
+}
+
+func writeResult(w http.ResponseWriter, result interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+}
+
+func writeError(w http.ResponseWriter, code int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": code, "description": description})
+}
+
+type fakeTransport struct {
+	server *FakeServer
+}
+
+func (t fakeTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	if r.Body != nil {
+		defer r.Body.Close()
+	}
+	recorder := httptest.NewRecorder()
+	t.server.ServeHTTP(recorder, r)
+	return recorder.Result(), nil
+}
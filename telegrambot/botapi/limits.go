@@ -0,0 +1,79 @@
+// Package botapi describes constraints of Telegram Bot API and provides a fake
+// Bot API server which checks requests against them.
+package botapi
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// Limits of Telegram Bot API, lengths are counted in UTF-16 code units.
+const (
+	MinPollOptionsCount      = 2
+	MaxPollOptionsCount      = 10
+	MaxPollQuestionLength    = 300
+	MaxPollOptionLength      = 100
+	MaxPollExplanationLength = 200
+	MaxMessageLength         = 4096
+	MaxCallbackDataLength    = 64
+	MaxCallbackAnswerLength  = 200
+)
+
+var (
+	// htmlTags are tags supported by Telegram in HTML parse mode.
+	htmlTags = map[string]bool{
+		"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+		"s": true, "strike": true, "del": true, "a": true, "code": true, "pre": true,
+	}
+	htmlTagRegexp    = regexp.MustCompile(`^<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^<>]*>`)
+	htmlEntityRegexp = regexp.MustCompile(`^&(#[0-9]+|#x[0-9a-fA-F]+|[a-zA-Z]+);`)
+	anyHTMLTagRegexp = regexp.MustCompile(`<[^>]*>`)
+)
+
+// CheckHTML checks that the text is accepted by Telegram in HTML parse mode:
+// only supported tags are used, they are balanced, and all &, < are escaped.
+func CheckHTML(text string) error {
+	openTags := make([]string, 0)
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '<':
+			match := htmlTagRegexp.FindStringSubmatch(text[i:])
+			if match == nil {
+				return fmt.Errorf("unescaped < at byte %d", i)
+			}
+			name := strings.ToLower(match[2])
+			if !htmlTags[name] {
+				return fmt.Errorf("unsupported tag <%s>", name)
+			}
+			if match[1] == "" {
+				openTags = append(openTags, name)
+			} else if len(openTags) == 0 || openTags[len(openTags)-1] != name {
+				return fmt.Errorf("unexpected closing tag </%s>", name)
+			} else {
+				openTags = openTags[:len(openTags)-1]
+			}
+			i += len(match[0]) - 1
+		case '&':
+			if !htmlEntityRegexp.MatchString(text[i:]) {
+				return fmt.Errorf("unescaped & at byte %d", i)
+			}
+		}
+	}
+	if len(openTags) > 0 {
+		return fmt.Errorf("unclosed tag <%s>", openTags[len(openTags)-1])
+	}
+	return nil
+}
+
+// HTMLTextLength returns length of the text as Telegram counts it after parsing
+// HTML markup.
+func HTMLTextLength(text string) int {
+	return TextLength(html.UnescapeString(anyHTMLTagRegexp.ReplaceAllString(text, "")))
+}
+
+func TextLength(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
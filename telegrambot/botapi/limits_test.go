@@ -0,0 +1,57 @@
+package botapi
+
+import (
+	"testing"
+)
+
+func TestCheckHTML(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected string
+	}{
+		{name: "plain text", text: "Сколько будет 2 + 2?"},
+		{name: "supported tags", text: `<b>a</b> <i><u>b</u></i> <a href="https://fipi.ru">c</a> <pre><code>d</code></pre>`},
+		{name: "upper case tags", text: "<B>a</B>"},
+		{name: "entities", text: "1 &lt; 2 &amp;&amp; 3 &gt; 2 &quot; &#39; &#x41;"},
+		{name: "unescaped less", text: "1 < 2", expected: "unescaped < at byte 2"},
+		{name: "unescaped ampersand", text: "a & b", expected: "unescaped & at byte 2"},
+		{name: "unknown entity end", text: "a &amp b", expected: "unescaped & at byte 2"},
+		{name: "unsupported tag", text: "<p>a</p>", expected: "unsupported tag <p>"},
+		{name: "unclosed tag", text: "<b><i>a</i>", expected: "unclosed tag <b>"},
+		{name: "unexpected closing tag", text: "a</b>", expected: "unexpected closing tag </b>"},
+		{name: "crossed tags", text: "<b><i>a</b></i>", expected: "unexpected closing tag </b>"},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			err := CheckHTML(testCase.text)
+			if testCase.expected == "" && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			} else if testCase.expected != "" && (err == nil || err.Error() != testCase.expected) {
+				t.Errorf("expected error %q, got %v", testCase.expected, err)
+			}
+		})
+	}
+}
+
+func TestHTMLTextLength(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		expected int
+	}{
+		{name: "empty", text: "", expected: 0},
+		{name: "ascii", text: "abc", expected: 3},
+		{name: "cyrillic", text: "Физика", expected: 6},
+		{name: "tags are not counted", text: `<b>a</b><a href="https://fipi.ru">b</a>`, expected: 2},
+		{name: "entities are one character", text: "&lt;&amp;&#x41;", expected: 3},
+		{name: "astral plane is two code units", text: "✅😀", expected: 3},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if length := HTMLTextLength(testCase.text); length != testCase.expected {
+				t.Errorf("expected %d, got %d", testCase.expected, length)
+			}
+		})
+	}
+}
@@ -0,0 +1,21 @@
+// Command fakebotapi serves a fake Telegram Bot API, so the bot can be run with
+// BOT_API_ENDPOINT pointing to it without network access.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+)
+
+func main() {
+	address := flag.String("address", ":8081", "Address to listen on")
+	flag.Parse()
+
+	log.Printf("Listening fake Bot API on address %s", *address)
+	if err := http.ListenAndServe(*address, botapi.NewFakeServer()); err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,180 @@
+package collection
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var themeCodeRegexp = regexp.MustCompile(`^\s*(\d+(?:\.\d+)*)\.?\s*(.*)$`)
+
+// CodifierNode is a section, a subsection or a theme of the exam codifier.
+// Tasks of a node include tasks of all its descendants.
+type CodifierNode struct {
+	Code     string
+	Name     string
+	Parent   *CodifierNode
+	Children []*CodifierNode
+	Tasks    []*Task
+}
+
+func (n *CodifierNode) String() string {
+	if n.Name == "" {
+		return n.Code
+	}
+	return n.Code + " " + n.Name
+}
+
+// IsRoot reports whether the node is the whole subject rather than a codifier entry.
+func (n *CodifierNode) IsRoot() bool {
+	return n.Parent == nil
+}
+
+// Section returns the top-level ancestor of the node.
+func (n *CodifierNode) Section() *CodifierNode {
+	if n.IsRoot() {
+		return n
+	}
+	for !n.Parent.IsRoot() {
+		n = n.Parent
+	}
+	return n
+}
+
+// Dictionaries are the FIPI reference lists cached by the crawler.
+type Dictionaries struct {
+	Themes []struct {
+		Name      string `json:"name"`
+		SubjectID int    `json:"subjectId"`
+	} `json:"themes"`
+	ThemeSections []struct {
+		Name      string `json:"name"`
+		SubjectID int    `json:"subjectId"`
+		Code      int    `json:"code"`
+	} `json:"themeSections"`
+}
+
+func ParseDictionariesFile(path string) (*Dictionaries, error) {
+	jsonData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dictionaries Dictionaries
+	if err := json.Unmarshal(jsonData, &dictionaries); err != nil {
+		return nil, err
+	}
+	return &dictionaries, nil
+}
+
+// getCodifierNames returns names of codifier entries of the FIPI subject by their codes.
+func (d *Dictionaries) getCodifierNames(fipiSubjectID int) map[string]string {
+	names := make(map[string]string)
+	if d == nil {
+		return names
+	}
+	for _, section := range d.ThemeSections {
+		if section.SubjectID == fipiSubjectID {
+			names[strconv.Itoa(section.Code)] = strings.TrimSpace(section.Name)
+		}
+	}
+	for _, theme := range d.Themes {
+		if theme.SubjectID != fipiSubjectID {
+			continue
+		}
+		if code, name := ParseThemeCode(theme.Name); code != "" && name != "" {
+			if _, found := names[code]; !found {
+				names[code] = name
+			}
+		}
+	}
+	return names
+}
+
+// ParseThemeCode splits a theme like "1.1.7 Свободное падение" into the codifier code and the name.
+func ParseThemeCode(theme string) (code, name string) {
+	match := themeCodeRegexp.FindStringSubmatch(theme)
+	if match == nil {
+		return "", strings.TrimSpace(theme)
+	}
+	return match[1], strings.TrimSpace(match[2])
+}
+
+// buildCodifier arranges tasks of the subject into a tree by codes of their themes.
+// Names come from the dictionaries if known and from the themes of tasks otherwise.
+func (s *Subject) buildCodifier(names map[string]string) {
+	root := &CodifierNode{Name: s.Name}
+	nodes := map[string]*CodifierNode{"": root}
+
+	var getNode func(code string) *CodifierNode
+	getNode = func(code string) *CodifierNode {
+		if node, found := nodes[code]; found {
+			return node
+		}
+		parentCode := ""
+		if i := strings.LastIndex(code, "."); i >= 0 {
+			parentCode = code[:i]
+		}
+		parent := getNode(parentCode)
+		node := &CodifierNode{Code: code, Name: names[code], Parent: parent}
+		parent.Children = append(parent.Children, node)
+		nodes[code] = node
+		return node
+	}
+
+	for _, task := range s.Tasks {
+		taskNodes := make(map[*CodifierNode]struct{})
+		for _, theme := range task.Themes {
+			code, name := ParseThemeCode(theme)
+			if code == "" {
+				continue
+			}
+			node := getNode(code)
+			if node.Name == "" {
+				node.Name = name
+			}
+			for ; node != nil; node = node.Parent {
+				taskNodes[node] = struct{}{}
+			}
+		}
+		if len(taskNodes) == 0 {
+			taskNodes[root] = struct{}{}
+		}
+		for node := range taskNodes {
+			node.Tasks = append(node.Tasks, task)
+		}
+	}
+
+	for _, node := range nodes {
+		sort.Slice(node.Children, func(i, j int) bool {
+			return compareCodes(node.Children[i].Code, node.Children[j].Code)
+		})
+	}
+	s.Codifier = root
+	s.codifierNodes = nodes
+}
+
+// GetCodifierNode returns the node by code. Empty code is the root of the subject.
+func (s *Subject) GetCodifierNode(code string) (*CodifierNode, bool) {
+	node, found := s.codifierNodes[code]
+	return node, found
+}
+
+// compareCodes orders codes numerically part by part, so "1.2" goes before "1.10".
+func compareCodes(a, b string) bool {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNumber, aErr := strconv.Atoi(aParts[i])
+		bNumber, bErr := strconv.Atoi(bParts[i])
+		if aErr != nil || bErr != nil {
+			return a < b
+		}
+		if aNumber != bNumber {
+			return aNumber < bNumber
+		}
+	}
+	return len(aParts) < len(bParts)
+}
@@ -0,0 +1,134 @@
+package collection
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParseThemeCode(t *testing.T) {
+	testCases := []struct {
+		theme string
+		code  string
+		name  string
+	}{
+		{theme: "1.1.7 Свободное падение", code: "1.1.7", name: "Свободное падение"},
+		{theme: "1.1.7. Свободное падение", code: "1.1.7", name: "Свободное падение"},
+		{theme: "  2 Термодинамика ", code: "2", name: "Термодинамика"},
+		{theme: "3.2", code: "3.2", name: ""},
+		{theme: "Механика", code: "", name: "Механика"},
+		{theme: " Закон 2 Ньютона ", code: "", name: "Закон 2 Ньютона"},
+		{theme: "", code: "", name: ""},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.theme, func(t *testing.T) {
+			code, name := ParseThemeCode(testCase.theme)
+			if code != testCase.code || name != testCase.name {
+				t.Errorf("expected %q, %q, got %q, %q", testCase.code, testCase.name, code, name)
+			}
+		})
+	}
+}
+
+func TestCompareCodes(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected bool
+	}{
+		{a: "1", b: "2", expected: true},
+		{a: "2", b: "1", expected: false},
+		{a: "1.2", b: "1.10", expected: true},
+		{a: "1.10", b: "1.2", expected: false},
+		{a: "1", b: "1.1", expected: true},
+		{a: "1.1", b: "1", expected: false},
+		{a: "1.1", b: "1.1", expected: false},
+		{a: "10", b: "9.1", expected: false},
+		{a: "a", b: "b", expected: true},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.a+" < "+testCase.b, func(t *testing.T) {
+			if less := compareCodes(testCase.a, testCase.b); less != testCase.expected {
+				t.Errorf("expected %v, got %v", testCase.expected, less)
+			}
+		})
+	}
+}
+
+func TestBuildCodifier(t *testing.T) {
+	testCases := []struct {
+		name     string
+		themes   [][]string
+		names    map[string]string
+		expected string
+	}{
+		{
+			name:     "no codes",
+			themes:   [][]string{{"Механика"}, nil},
+			expected: "Физика: 1 2\n",
+		},
+		{
+			name:   "parents are created",
+			themes: [][]string{{"1.1.7 Свободное падение"}, {"1.2 Динамика"}},
+			expected: "Физика: 1 2\n" +
+				" 1: 1 2\n" +
+				"  1.1: 1\n" +
+				"   1.1.7 Свободное падение: 1\n" +
+				"  1.2 Динамика: 2\n",
+		},
+		{
+			name:   "names of dictionaries go first",
+			themes: [][]string{{"1.1 Кинематика точки"}},
+			names:  map[string]string{"1": "Механика", "1.1": "Кинематика"},
+			expected: "Физика: 1\n" +
+				" 1 Механика: 1\n" +
+				"  1.1 Кинематика: 1\n",
+		},
+		{
+			name:   "children are ordered by codes",
+			themes: [][]string{{"1.10 Десятая"}, {"1.2 Вторая"}, {"2 Второй"}},
+			expected: "Физика: 1 2 3\n" +
+				" 1: 1 2\n" +
+				"  1.2 Вторая: 2\n" +
+				"  1.10 Десятая: 1\n" +
+				" 2 Второй: 3\n",
+		},
+		{
+			name:   "task of several themes is counted once",
+			themes: [][]string{{"1.1 Кинематика", "1.2 Динамика"}},
+			expected: "Физика: 1\n" +
+				" 1: 1\n" +
+				"  1.1 Кинематика: 1\n" +
+				"  1.2 Динамика: 1\n",
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			subject := &Subject{Name: "Физика"}
+			for i, themes := range testCase.themes {
+				subject.Tasks = append(subject.Tasks, &Task{ID: i + 1, Themes: themes})
+			}
+			subject.buildCodifier(testCase.names)
+			var builder strings.Builder
+			writeCodifierNode(&builder, subject.Codifier, 0)
+			if builder.String() != testCase.expected {
+				t.Errorf("expected:\n%s\ngot:\n%s", testCase.expected, builder.String())
+			}
+		})
+	}
+}
+
+// writeCodifierNode writes the node and its descendants with IDs of their tasks.
+func writeCodifierNode(builder *strings.Builder, node *CodifierNode, depth int) {
+	if node.IsRoot() {
+		builder.WriteString(node.Name + ":")
+	} else {
+		builder.WriteString(strings.Repeat(" ", depth) + node.String() + ":")
+	}
+	for _, task := range node.Tasks {
+		fmt.Fprintf(builder, " %d", task.ID)
+	}
+	builder.WriteString("\n")
+	for _, child := range node.Children {
+		writeCodifierNode(builder, child, depth+1)
+	}
+}
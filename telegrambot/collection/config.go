@@ -0,0 +1,94 @@
+package collection
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const maxSubjectIDLength = 32
+
+// Config lists subjects served by the bot. Relative paths are resolved against
+// the directory of the config file.
+type Config struct {
+	Dictionaries string          `json:"dictionaries"`
+	Subjects     []SubjectConfig `json:"subjects"`
+}
+
+type SubjectConfig struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Order   int    `json:"order"`
+	Enabled bool   `json:"enabled"`
+	// FIPIID is the identifier of the subject in FIPI dictionaries.
+	FIPIID int `json:"fipiId"`
+}
+
+func ParseConfigFile(path string) (*Config, error) {
+	jsonData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(jsonData, &config); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	baseDir := filepath.Dir(path)
+	config.Dictionaries = resolvePath(baseDir, config.Dictionaries)
+	for i := range config.Subjects {
+		config.Subjects[i].Path = resolvePath(baseDir, config.Subjects[i].Path)
+	}
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("config %s: %v", path, err)
+	}
+	return &config, nil
+}
+
+// EnabledSubjects returns enabled subjects in display order.
+func (c *Config) EnabledSubjects() []SubjectConfig {
+	subjects := make([]SubjectConfig, 0, len(c.Subjects))
+	for _, subject := range c.Subjects {
+		if subject.Enabled {
+			subjects = append(subjects, subject)
+		}
+	}
+	sort.SliceStable(subjects, func(i, j int) bool {
+		return subjects[i].Order < subjects[j].Order
+	})
+	return subjects
+}
+
+func (c *Config) validate() error {
+	ids := make(map[string]struct{})
+	names := make(map[string]struct{})
+	for i, subject := range c.Subjects {
+		if subject.ID == "" || subject.Name == "" || subject.Path == "" {
+			return fmt.Errorf("subject #%d: id, name and path are required", i+1)
+		}
+		// IDs are put into callback data, which is split by these characters and
+		// limited to 64 bytes.
+		if strings.ContainsAny(subject.ID, ":|") || len(subject.ID) > maxSubjectIDLength {
+			return fmt.Errorf("subject id %q must be at most %d bytes without : and |", subject.ID, maxSubjectIDLength)
+		}
+		if _, found := ids[subject.ID]; found {
+			return fmt.Errorf("duplicate subject id %q", subject.ID)
+		}
+		if _, found := names[subject.Name]; found {
+			return fmt.Errorf("duplicate subject name %q", subject.Name)
+		}
+		ids[subject.ID] = struct{}{}
+		names[subject.Name] = struct{}{}
+	}
+	return nil
+}
+
+func resolvePath(baseDir, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
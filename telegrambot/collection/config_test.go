@@ -0,0 +1,25 @@
+package collection
+
+import (
+	"testing"
+)
+
+func TestConfigValidate(t *testing.T) {
+	testCases := []struct {
+		id    string
+		valid bool
+	}{
+		{"physics", true},
+		{"math_advanced", true},
+		{"", false},
+		{"a:b", false},
+		{"a|b", false},
+		{"физика_физика_физика", false},
+	}
+	for _, testCase := range testCases {
+		config := Config{Subjects: []SubjectConfig{{ID: testCase.id, Name: "Физика", Path: "physics.json"}}}
+		if err := config.validate(); (err == nil) != testCase.valid {
+			t.Errorf("id %q: expected valid %v, got %v", testCase.id, testCase.valid, err)
+		}
+	}
+}
@@ -1,54 +1,100 @@
 package collection
 
 import (
-	"log"
+	"fmt"
+	"strings"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
 )
 
 type Database struct {
+	// Subjects are kept by ID from the config, which stays the same when a
+	// subject is renamed.
 	Subjects map[string]*Subject
+	Tasks    map[int]*Task
+
+	// SubjectIDs are IDs of subjects in display order.
+	SubjectIDs []string
+	// SkippedTasks are tasks left out because they can't be shown or answered
+	// or their IDs are taken by other tasks.
+	SkippedTasks []Problem
+
+	subjectIDsByName map[string]string
 }
 
-func NewDatabase(
-	russianSubjectPath string,
-	mathAdvancedSubjectPath string,
-	mathBasicSubjectPath string,
-	physicsSubjectPath string,
-	chemistrySubjectPath string,
-	itSubjectPath string,
-	biologySubjectPath string,
-	historySubjectPath string,
-	geographySubjectPath string,
-	englishSubjectPath string,
-	germanSubjectPath string,
-	frenchSubjectPath string,
-	socialSubjectPath string,
-	spanishSubjectPath string,
-	literatureSubjectPath string,
-) *Database {
-
-	return &Database{
-		Subjects: map[string]*Subject{
-			SubjectNameRussian:      parseSubjectFileOrPanic(SubjectNameRussian, russianSubjectPath),
-			SubjectNameMathAdvanced: parseSubjectFileOrPanic(SubjectNameMathAdvanced, mathAdvancedSubjectPath),
-			SubjectNameMathBasic:    parseSubjectFileOrPanic(SubjectNameMathBasic, mathBasicSubjectPath),
-			SubjectNamePhysics:      parseSubjectFileOrPanic(SubjectNamePhysics, physicsSubjectPath),
-			SubjectNameChemistry:    parseSubjectFileOrPanic(SubjectNameChemistry, chemistrySubjectPath),
-			SubjectNameIT:           parseSubjectFileOrPanic(SubjectNameIT, itSubjectPath),
-			SubjectNameBiology:      parseSubjectFileOrPanic(SubjectNameBiology, biologySubjectPath),
-			SubjectNameHistory:      parseSubjectFileOrPanic(SubjectNameHistory, historySubjectPath),
-			SubjectNameGeography:    parseSubjectFileOrPanic(SubjectNameGeography, geographySubjectPath),
-			SubjectNameEnglish:      parseSubjectFileOrPanic(SubjectNameEnglish, englishSubjectPath),
-			SubjectNameGerman:       parseSubjectFileOrPanic(SubjectNameGerman, germanSubjectPath),
-			SubjectNameFrench:       parseSubjectFileOrPanic(SubjectNameFrench, frenchSubjectPath),
-			SubjectNameSocial:       parseSubjectFileOrPanic(SubjectNameSocial, socialSubjectPath),
-			SubjectNameSpanish:      parseSubjectFileOrPanic(SubjectNameSpanish, spanishSubjectPath),
-			SubjectNameLiterature:   parseSubjectFileOrPanic(SubjectNameLiterature, literatureSubjectPath),
-		},
+func NewDatabase(config *Config) (*Database, error) {
+	var dictionaries *Dictionaries
+	if config.Dictionaries != "" {
+		var err error
+		if dictionaries, err = ParseDictionariesFile(config.Dictionaries); err != nil {
+			return nil, fmt.Errorf("dictionaries %s: %v", config.Dictionaries, err)
+		}
+	} else {
+		logging.Warn("Dictionaries path is empty, codifier names will be taken from tasks")
+	}
+
+	database := &Database{
+		Subjects:         make(map[string]*Subject),
+		Tasks:            make(map[int]*Task),
+		subjectIDsByName: make(map[string]string),
+	}
+	for _, subjectConfig := range config.EnabledSubjects() {
+		subject, err := parseSubjectFile(subjectConfig)
+		if err != nil {
+			return nil, err
+		}
+		database.addSubject(subject, dictionaries.getCodifierNames(subjectConfig.FIPIID))
 	}
+	return database, nil
 }
 
 func (d *Database) Show() {
-	for name, subject := range d.Subjects {
-		log.Printf("%s: %s", name, subject)
+	for _, subjectID := range d.SubjectIDs {
+		subject := d.Subjects[subjectID]
+		logging.Info("Subject loaded", logging.String("subject", subject.Name), logging.String("summary", subject.String()))
+	}
+}
+
+func (d *Database) GetTask(taskID int) (*Task, bool) {
+	task, found := d.Tasks[taskID]
+	return task, found
+}
+
+func (d *Database) GetSubjectByID(subjectID string) (*Subject, bool) {
+	subject, found := d.Subjects[subjectID]
+	return subject, found
+}
+
+// GetSubjectByName finds subjects stored by name before they were stored by ID.
+func (d *Database) GetSubjectByName(name string) (*Subject, bool) {
+	return d.GetSubjectByID(d.subjectIDsByName[name])
+}
+
+// addSubject indexes tasks of the subject by ID and builds its themes, levels and
+// codifier. A broken task is skipped and logged instead of failing the whole
+// load, the validate subcommand is there to report it strictly.
+func (d *Database) addSubject(subject *Subject, codifierNames map[string]string) {
+	tasks := make([]*Task, 0, len(subject.Tasks))
+	for _, task := range subject.Tasks {
+		problem := ""
+		if other, found := d.Tasks[task.ID]; found {
+			problem = fmt.Sprintf("duplicate id, also in %s", other.SubjectName)
+		} else if problems := task.validate(); len(problems) > 0 {
+			problem = strings.Join(problems, "; ")
+		}
+		if problem != "" {
+			logging.Warn("Task is skipped", logging.String("subject", subject.Name), logging.Int("taskId", task.ID), logging.String("problem", problem))
+			d.SkippedTasks = append(d.SkippedTasks, Problem{subject.Name, task.ID, problem})
+			continue
+		}
+		d.Tasks[task.ID] = task
+		tasks = append(tasks, task)
 	}
+	subject.Tasks = tasks
+	subject.extractAllThemes()
+	subject.groupTasksByLevels()
+	subject.buildCodifier(codifierNames)
+	d.Subjects[subject.ID] = subject
+	d.subjectIDsByName[subject.Name] = subject.ID
+	d.SubjectIDs = append(d.SubjectIDs, subject.ID)
 }
@@ -0,0 +1,73 @@
+package collection
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestNewDatabaseSkipsBrokenTasks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"subjects.json": `{"subjects": [
+			{"id": "physics", "name": "Физика", "path": "physics.json", "order": 1, "enabled": true},
+			{"id": "math", "name": "Математика", "path": "math.json", "order": 2, "enabled": true}
+		]}`,
+		"physics.json": `{"tasks": [
+			{"id": 1, "level": 1, "text": "a", "answer": "1", "options": {"1": "1", "2": "2"}, "themes": ["1.1 Кинематика"]},
+			{"id": 2, "level": 1, "text": "b", "answer": "3", "options": {"1": "1", "2": "2"}, "themes": ["1.2 Динамика"]},
+			{"id": 3, "level": 9, "text": "c", "answer": "1", "options": {"1": "1", "2": "2"}}
+		]}`,
+		"math.json": `{"tasks": [
+			{"id": 1, "level": 2, "text": "d", "answer": "1", "options": {"1": "1", "2": "2"}},
+			{"id": 4, "level": 2, "text": "e", "answer": "1", "options": {"1": "1", "2": "2"}}
+		]}`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := ParseConfigFile(filepath.Join(dir, "subjects.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	database, err := NewDatabase(config)
+	if err != nil {
+		t.Fatalf("expected broken tasks to be skipped, got %v", err)
+	}
+	taskIDs := make([]int, 0, len(database.Tasks))
+	for taskID := range database.Tasks {
+		taskIDs = append(taskIDs, taskID)
+	}
+	sort.Ints(taskIDs)
+	if !reflect.DeepEqual(taskIDs, []int{1, 4}) {
+		t.Errorf("expected tasks 1 and 4, got %v", taskIDs)
+	}
+	if task := database.Tasks[1]; task.SubjectID != "physics" {
+		t.Errorf("expected task 1 of the first subject to be kept, got %s", task.SubjectID)
+	}
+	physics := database.Subjects["physics"]
+	if len(physics.Tasks) != 1 || len(physics.LowLevelTasks) != 1 || !reflect.DeepEqual(physics.AllThemes, []string{"1.1 Кинематика"}) {
+		t.Errorf("expected skipped tasks to be left out of the subject, got %s with themes %v", physics, physics.AllThemes)
+	}
+	if _, found := physics.GetCodifierNode("1.2"); found {
+		t.Error("expected theme of a skipped task to be left out of the codifier")
+	}
+	expected := []Problem{
+		{"Физика", 2, `answer "3" is not among options`},
+		{"Физика", 3, "unknown level 9"},
+		{"Математика", 1, "duplicate id, also in Физика"},
+	}
+	if !reflect.DeepEqual(database.SkippedTasks, expected) {
+		t.Errorf("expected skipped tasks %v, got %v", expected, database.SkippedTasks)
+	}
+}
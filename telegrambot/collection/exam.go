@@ -0,0 +1,60 @@
+package collection
+
+import (
+	"math/rand"
+)
+
+// Exam variants are drawn by levels in the same proportion as in the real exam.
+var examLevelCounts = []struct {
+	Level Level
+	Count int
+}{
+	{LevelLow, 12},
+	{LevelMedium, 6},
+	{LevelHigh, 2},
+}
+
+// Points returns the primary score for the correct answer to a task of the level.
+func (l Level) Points() int {
+	switch l {
+	case LevelLow:
+		return 1
+	case LevelMedium:
+		return 2
+	case LevelHigh:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// MakeExamVariant draws distinct random tasks ordered by levels. Levels without
+// enough tasks are made up with tasks of other levels. Only tasks passed by the
+// filter are drawn.
+func (s *Subject) MakeExamVariant(filter func(tasks []*Task) []*Task) []*Task {
+	variantSize := 0
+	for _, levelCount := range examLevelCounts {
+		variantSize += levelCount.Count
+	}
+
+	used := make(map[*Task]struct{}, variantSize)
+	variant := make([]*Task, 0, variantSize)
+	addRandomTasks := func(tasks []*Task, count int) {
+		for _, i := range rand.Perm(len(tasks)) {
+			if count == 0 || len(variant) == variantSize {
+				return
+			}
+			if _, found := used[tasks[i]]; found {
+				continue
+			}
+			used[tasks[i]] = struct{}{}
+			variant = append(variant, tasks[i])
+			count--
+		}
+	}
+	for _, levelCount := range examLevelCounts {
+		addRandomTasks(filter(s.GetTasks(levelCount.Level, "")), levelCount.Count)
+	}
+	addRandomTasks(filter(s.Tasks), variantSize-len(variant))
+	return variant
+}
@@ -0,0 +1,132 @@
+package collection
+
+import (
+	"testing"
+)
+
+func makeTestSubject(counts map[Level]int) *Subject {
+	subject := &Subject{}
+	for _, level := range []Level{LevelLow, LevelMedium, LevelHigh} {
+		for i := 0; i < counts[level]; i++ {
+			subject.Tasks = append(subject.Tasks, &Task{ID: len(subject.Tasks) + 1, Level: level})
+		}
+	}
+	subject.groupTasksByLevels()
+	return subject
+}
+
+func noFilter(tasks []*Task) []*Task {
+	return tasks
+}
+
+func TestMakeExamVariant(t *testing.T) {
+	testCases := []struct {
+		name     string
+		counts   map[Level]int
+		filter   func(tasks []*Task) []*Task
+		expected []Level
+		// madeUp is the number of tasks of any level at the end of the variant.
+		madeUp int
+	}{
+		{
+			name:     "enough tasks",
+			counts:   map[Level]int{LevelLow: 20, LevelMedium: 10, LevelHigh: 5},
+			filter:   noFilter,
+			expected: repeatLevels(12, 6, 2),
+		},
+		{
+			name:     "no high level tasks",
+			counts:   map[Level]int{LevelLow: 20, LevelMedium: 10},
+			filter:   noFilter,
+			expected: repeatLevels(12, 6, 0),
+			madeUp:   2,
+		},
+		{
+			name:     "less tasks than in variant",
+			counts:   map[Level]int{LevelLow: 3, LevelMedium: 1, LevelHigh: 1},
+			filter:   noFilter,
+			expected: repeatLevels(3, 1, 1),
+		},
+		{
+			name:   "filtered",
+			counts: map[Level]int{LevelLow: 20, LevelMedium: 10, LevelHigh: 5},
+			filter: func(tasks []*Task) []*Task {
+				filtered := make([]*Task, 0)
+				for _, task := range tasks {
+					if task.Level != LevelHigh {
+						filtered = append(filtered, task)
+					}
+				}
+				return filtered
+			},
+			expected: repeatLevels(12, 6, 0),
+			madeUp:   2,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			subject := makeTestSubject(testCase.counts)
+			allowed := make(map[int]struct{})
+			for _, task := range testCase.filter(subject.Tasks) {
+				allowed[task.ID] = struct{}{}
+			}
+			variant := subject.MakeExamVariant(testCase.filter)
+			levels := make([]Level, len(variant))
+			used := make(map[int]struct{})
+			for i, task := range variant {
+				levels[i] = task.Level
+				if _, found := used[task.ID]; found {
+					t.Errorf("task %d is drawn twice", task.ID)
+				}
+				if _, found := allowed[task.ID]; !found {
+					t.Errorf("task %d is not passed by the filter", task.ID)
+				}
+				used[task.ID] = struct{}{}
+			}
+			if len(levels) != len(testCase.expected)+testCase.madeUp {
+				t.Fatalf("expected levels %v and %d more, got %v", testCase.expected, testCase.madeUp, levels)
+			}
+			for i := range testCase.expected {
+				if levels[i] != testCase.expected[i] {
+					t.Fatalf("expected levels %v and %d more, got %v", testCase.expected, testCase.madeUp, levels)
+				}
+			}
+		})
+	}
+}
+
+// repeatLevels returns levels of a variant ordered as they are drawn.
+func repeatLevels(low, medium, high int) []Level {
+	levels := make([]Level, 0, low+medium+high)
+	for level, count := range []int{low, medium, high} {
+		for i := 0; i < count; i++ {
+			levels = append(levels, Level(level+1))
+		}
+	}
+	return levels
+}
+
+func TestLevelPoints(t *testing.T) {
+	testCases := []struct {
+		level    Level
+		expected int
+	}{
+		{LevelLow, 1},
+		{LevelMedium, 2},
+		{LevelHigh, 3},
+		{Level(0), 0},
+	}
+	for _, testCase := range testCases {
+		if points := testCase.level.Points(); points != testCase.expected {
+			t.Errorf("level %d: expected %d points, got %d", testCase.level, testCase.expected, points)
+		}
+	}
+
+	maxScore := 0
+	for _, levelCount := range examLevelCounts {
+		maxScore += levelCount.Count * levelCount.Level.Points()
+	}
+	if maxScore != 30 {
+		t.Errorf("expected max score of a full variant to be 30, got %d", maxScore)
+	}
+}
@@ -0,0 +1,94 @@
+package collection
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+// Loader keeps the database built from the subjects config and replaces it
+// atomically on reload. Readers keep using the database they have already got,
+// so a reload never interrupts a request in flight.
+type Loader struct {
+	configPath string
+	mutex      sync.Mutex
+	current    atomic.Value
+
+	statusMutex sync.Mutex
+	status      LoaderStatus
+}
+
+// LoaderStatus describes the result of the last load.
+type LoaderStatus struct {
+	LoadedAt  time.Time
+	LastError error
+}
+
+func NewLoader(configPath string) (*Loader, error) {
+	loader := &Loader{configPath: configPath}
+	if _, err := loader.Reload(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+func (l *Loader) Database() *Database {
+	return l.current.Load().(*Database)
+}
+
+// Reload parses the config and all subject files again. If anything is wrong with
+// the new data, the error is returned and the current database is kept.
+func (l *Loader) Reload() (*Database, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	database, err := l.load()
+	l.statusMutex.Lock()
+	defer l.statusMutex.Unlock()
+	l.status.LastError = err
+	if err != nil {
+		return nil, err
+	}
+	l.status.LoadedAt = time.Now()
+	l.current.Store(database)
+	logging.Info("Database loaded", logging.String("path", l.configPath), logging.Int("tasks", len(database.Tasks)), logging.Int("skippedTasks", len(database.SkippedTasks)))
+	return database, nil
+}
+
+// Status returns when the current database was loaded and the error of the last
+// reload if it has failed.
+func (l *Loader) Status() LoaderStatus {
+	l.statusMutex.Lock()
+	defer l.statusMutex.Unlock()
+	return l.status
+}
+
+func (l *Loader) load() (*Database, error) {
+	config, err := ParseConfigFile(l.configPath)
+	if err != nil {
+		return nil, err
+	}
+	database, err := NewDatabase(config)
+	if err != nil {
+		return nil, err
+	}
+	if current, ok := l.current.Load().(*Database); ok {
+		if err := checkNoSubjectEmptied(current, database); err != nil {
+			return nil, err
+		}
+	}
+	return database, nil
+}
+
+// checkNoSubjectEmptied rejects data where a subject lost all its tasks, which
+// usually means that the crawler has failed in the middle of an update.
+func checkNoSubjectEmptied(current, next *Database) error {
+	for _, subjectID := range next.SubjectIDs {
+		if subject, found := current.Subjects[subjectID]; found && len(subject.Tasks) > 0 && len(next.Subjects[subjectID].Tasks) == 0 {
+			return fmt.Errorf("subject %s has no tasks anymore", subject.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package collection
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+	"github.com/ravil23/usebot/telegrambot/render"
+)
+
+const subjectsConfigPath = "../../data/gia11/fipi/subjects.json"
+
+var update = flag.Bool("update", false, "update golden files")
+
+// TestRenderGolden renders messages of all tasks in data and compares them with
+// golden files, run with -update after intended changes of rendering.
+func TestRenderGolden(t *testing.T) {
+	config, err := ParseConfigFile(subjectsConfigPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subjectConfig := range config.Subjects {
+		subjectConfig := subjectConfig
+		t.Run(subjectConfig.ID, func(t *testing.T) {
+			subject, err := parseSubjectFile(subjectConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buffer bytes.Buffer
+			for _, task := range subject.Tasks {
+				text := render.HTML(task.renderMessage(task.getTextWithSubject(), task.sortedOptionKeys()))
+				if err := botapi.CheckHTML(text); err != nil {
+					t.Errorf("task %d: %v", task.ID, err)
+				}
+				fmt.Fprintf(&buffer, "=== %d\n%s\n", task.ID, text)
+			}
+
+			path := filepath.Join("testdata", subjectConfig.ID+".golden")
+			if *update {
+				if err := ioutil.WriteFile(path, buffer.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			expected, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buffer.Bytes(), expected) {
+				t.Errorf("rendered messages differ from %s, run with -update if it is intended", path)
+			}
+		})
+	}
+}
@@ -4,54 +4,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"sort"
 )
 
-const (
-	SubjectNameRussian      = "Русский язык"
-	SubjectNameMathAdvanced = "Математика (профильный)"
-	SubjectNameMathBasic    = "Математика (базовый)"
-	SubjectNamePhysics      = "Физика"
-	SubjectNameChemistry    = "Химия"
-	SubjectNameIT           = "Информатика и ИКТ"
-	SubjectNameBiology      = "Биология"
-	SubjectNameHistory      = "История"
-	SubjectNameGeography    = "География"
-	SubjectNameEnglish      = "Английский"
-	SubjectNameGerman       = "Немецкий"
-	SubjectNameFrench       = "Французский"
-	SubjectNameSocial       = "Обществознание"
-	SubjectNameSpanish      = "Испанский"
-	SubjectNameLiterature   = "Литература"
-)
-
-var AllSubjectNames = []string{
-	SubjectNameRussian,
-	SubjectNameMathAdvanced,
-	SubjectNameMathBasic,
-	SubjectNamePhysics,
-	SubjectNameIT,
-	SubjectNameChemistry,
-	SubjectNameBiology,
-	SubjectNameGeography,
-	SubjectNameHistory,
-	SubjectNameSocial,
-	SubjectNameEnglish,
-	SubjectNameGerman,
-	SubjectNameFrench,
-	SubjectNameSpanish,
-	SubjectNameLiterature,
-}
-
 type Subject struct {
 	Tasks []*Task `json:"tasks"`
 
+	ID               string   `json:"-"`
 	Name             string   `json:"-"`
 	LowLevelTasks    []*Task  `json:"-"`
 	MediumLevelTasks []*Task  `json:"-"`
 	HighLevelTasks   []*Task  `json:"-"`
 	AllThemes        []string `json:"-"`
+
+	Codifier      *CodifierNode `json:"-"`
+	codifierNodes map[string]*CodifierNode
 }
 
 func (s *Subject) String() string {
@@ -93,29 +60,46 @@ func (s *Subject) groupTasksByLevels() {
 	}
 }
 
-func parseSubjectFile(name, path string) (*Subject, error) {
-	jsonData, err := ioutil.ReadFile(path)
+// GetTasks returns tasks of the level within the codifier section or theme.
+// Empty or unknown code matches any task.
+func (s *Subject) GetTasks(level Level, code string) []*Task {
+	node, found := s.GetCodifierNode(code)
+	if !found || node.IsRoot() {
+		switch level {
+		case LevelLow:
+			return s.LowLevelTasks
+		case LevelMedium:
+			return s.MediumLevelTasks
+		case LevelHigh:
+			return s.HighLevelTasks
+		}
+	}
+	tasks := make([]*Task, 0)
+	for _, task := range node.Tasks {
+		if task.Level == level {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+
+// parseSubjectFile reads tasks of the subject. Themes, levels and the codifier are
+// built by the database once broken tasks are skipped.
+func parseSubjectFile(config SubjectConfig) (*Subject, error) {
+	jsonData, err := ioutil.ReadFile(config.Path)
 	if err != nil {
 		return nil, err
 	}
 	var subject Subject
 	err = json.Unmarshal(jsonData, &subject)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("subject %s: %v", config.Path, err)
 	}
-	subject.extractAllThemes()
-	subject.groupTasksByLevels()
 	for _, task := range subject.Tasks {
-		task.SubjectName = name
+		task.SubjectID = config.ID
+		task.SubjectName = config.Name
 	}
-	subject.Name = name
+	subject.ID = config.ID
+	subject.Name = config.Name
 	return &subject, nil
 }
-
-func parseSubjectFileOrPanic(name, path string) *Subject {
-	subject, err := parseSubjectFile(name, path)
-	if err != nil {
-		log.Panic(err)
-	}
-	return subject
-}
@@ -3,11 +3,15 @@ package collection
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"math/rand"
+	"sort"
 	"strconv"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+	"github.com/ravil23/usebot/telegrambot/logging"
+	"github.com/ravil23/usebot/telegrambot/render"
 )
 
 const (
@@ -45,6 +49,7 @@ type Task struct {
 	Themes       []string          `json:"themes"`
 	Requirements []string          `json:"requirements"`
 	SendAsPoll   bool              `json:"sendAsPoll"`
+	SubjectID    string            `json:"-"`
 	SubjectName  string            `json:"subjectName"`
 }
 
@@ -56,8 +61,23 @@ func (t *Task) String() string {
 	}
 }
 
+// validate returns problems which make the task impossible to show or answer.
+func (t *Task) validate() []string {
+	problems := make([]string, 0)
+	if t.Level.String() == "" {
+		problems = append(problems, fmt.Sprintf("unknown level %d", t.Level))
+	}
+	if len(t.Options) < botapi.MinPollOptionsCount {
+		problems = append(problems, fmt.Sprintf("%d options, min is %d", len(t.Options), botapi.MinPollOptionsCount))
+	}
+	if _, found := t.Options[t.Answer]; !found {
+		problems = append(problems, fmt.Sprintf("answer %q is not among options", t.Answer))
+	}
+	return problems
+}
+
 func (t *Task) MakeTelegramPoll(chatID int64) *tgbotapi.SendPollConfig {
-	log.Printf("Make poll to chat %d from task: %s", chatID, t)
+	logging.Debug("Make poll", logging.ChatID(chatID), logging.Int("taskId", t.ID), logging.TaskBody("task", t.String()))
 	var correctOptionID int64 = -1
 	tgOptions := make([]string, 0, len(t.Options))
 	for i, key := range t.shuffledOptionKeys() {
@@ -75,35 +95,61 @@ func (t *Task) MakeTelegramPoll(chatID int64) *tgbotapi.SendPollConfig {
 	return &tgPoll
 }
 
-func (t *Task) MakeTelegramMessage(chatID int64) *tgbotapi.MessageConfig {
-	log.Printf("Make message to chat %d from task: %s", chatID, t)
-	tgMessage := tgbotapi.NewMessage(chatID, fmt.Sprintf("<b>%s</b>\n", t.getTextWithSubject()))
-	if t.Doc != "" {
-		tgMessage.Text += "\n" + t.Doc + "\n"
-	}
+// MakeTelegramMessage makes a message with a button per option. Callback data of
+// a button is made from the option key and must not reveal the answer.
+func (t *Task) MakeTelegramMessage(chatID int64, makeCallbackData func(key string) string) *tgbotapi.MessageConfig {
+	logging.Debug("Make message", logging.ChatID(chatID), logging.Int("taskId", t.ID), logging.TaskBody("task", t.String()))
+	return t.makeTelegramMessage(chatID, t.getTextWithSubject(), makeCallbackData)
+}
+
+func (t *Task) MakeTelegramExamMessage(chatID int64, header string, makeCallbackData func(key string) string) *tgbotapi.MessageConfig {
+	logging.Debug("Make exam message", logging.ChatID(chatID), logging.Int("taskId", t.ID), logging.TaskBody("task", t.String()))
+	return t.makeTelegramMessage(chatID, fmt.Sprintf("%s\n%s", header, t.Text), makeCallbackData)
+}
+
+func (t *Task) makeTelegramMessage(chatID int64, title string, makeCallbackData func(key string) string) *tgbotapi.MessageConfig {
+	keys := t.shuffledOptionKeys()
+	tgMessage := tgbotapi.NewMessage(chatID, render.HTML(t.renderMessage(title, keys)))
 	tgMessage.ParseMode = tgbotapi.ModeHTML
-	tgButtons := make([]tgbotapi.InlineKeyboardButton, len(t.Options))
-	for i, key := range t.shuffledOptionKeys() {
-		option := t.Options[key]
-		index := i + 1
-		tgMessage.Text += fmt.Sprintf("\n%d. %s", index, option)
-		tgButtons[i] = tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(index), fmt.Sprintf("%d:%t", index, key == t.Answer))
+	tgButtons := make([]tgbotapi.InlineKeyboardButton, len(keys))
+	for i, key := range keys {
+		tgButtons[i] = tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(i+1), makeCallbackData(key))
 	}
 	tgMessage.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgButtons)
 	return &tgMessage
 }
 
+// renderMessage makes text of the message with the bold title, the document and
+// options numbered in order of keys. Task data is plain text, so it is escaped.
+func (t *Task) renderMessage(title string, keys []string) render.Node {
+	lines := []render.Node{render.Bold(render.Text(title))}
+	if t.Doc != "" {
+		lines = append(lines, render.Text(""), render.Text(t.Doc))
+	}
+	lines = append(lines, render.Text(""))
+	for i, key := range keys {
+		lines = append(lines, render.Text(fmt.Sprintf("%d. %s", i+1, t.Options[key])))
+	}
+	return render.Lines(lines...)
+}
+
 func (t *Task) getTextWithSubject() string {
 	return fmt.Sprintf("%s\n%s", t.SubjectName, t.Text)
 }
 
 func (t *Task) shuffledOptionKeys() []string {
+	keys := t.sortedOptionKeys()
+	rand.Shuffle(len(keys), func(i, j int) {
+		keys[i], keys[j] = keys[j], keys[i]
+	})
+	return keys
+}
+
+func (t *Task) sortedOptionKeys() []string {
 	keys := make([]string, 0, len(t.Options))
 	for key := range t.Options {
 		keys = append(keys, key)
 	}
-	rand.Shuffle(len(keys), func(i, j int) {
-		keys[i], keys[j] = keys[j], keys[i]
-	})
+	sort.Strings(keys)
 	return keys
 }
@@ -0,0 +1,85 @@
+package collection
+
+import (
+	"fmt"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+	"github.com/ravil23/usebot/telegrambot/render"
+)
+
+// Problem is an issue with task data found by Validate or a task skipped by
+// NewDatabase. TaskID is zero for problems with a whole file.
+type Problem struct {
+	SubjectName string
+	TaskID      int
+	Text        string
+}
+
+func (p Problem) String() string {
+	if p.TaskID == 0 {
+		return fmt.Sprintf("%s: %s", p.SubjectName, p.Text)
+	}
+	return fmt.Sprintf("%s: task %d: %s", p.SubjectName, p.TaskID, p.Text)
+}
+
+// Validate checks data of all enabled subjects and reports every problem found,
+// unlike NewDatabase which only skips tasks that can't be shown or answered.
+func Validate(config *Config) []Problem {
+	problems := make([]Problem, 0)
+	if config.Dictionaries != "" {
+		if _, err := ParseDictionariesFile(config.Dictionaries); err != nil {
+			problems = append(problems, Problem{SubjectName: config.Dictionaries, Text: err.Error()})
+		}
+	}
+	subjectNamesByTaskID := make(map[int]string)
+	for _, subjectConfig := range config.EnabledSubjects() {
+		subject, err := parseSubjectFile(subjectConfig)
+		if err != nil {
+			problems = append(problems, Problem{SubjectName: subjectConfig.Name, Text: err.Error()})
+			continue
+		}
+		for _, task := range subject.Tasks {
+			if other, found := subjectNamesByTaskID[task.ID]; found {
+				problems = append(problems, Problem{subject.Name, task.ID, fmt.Sprintf("duplicate id, also in %s", other)})
+			} else {
+				subjectNamesByTaskID[task.ID] = subject.Name
+			}
+			for _, text := range task.problems() {
+				problems = append(problems, Problem{subject.Name, task.ID, text})
+			}
+		}
+	}
+	return problems
+}
+
+// problems returns all problems of the task. Lengths and HTML are checked even if
+// the task is broken, as long as they can be computed.
+func (t *Task) problems() []string {
+	problems := t.validate()
+	if t.SendAsPoll {
+		if len(t.Options) > botapi.MaxPollOptionsCount {
+			problems = append(problems, fmt.Sprintf("poll has %d options, max is %d", len(t.Options), botapi.MaxPollOptionsCount))
+		}
+		if length := botapi.TextLength(t.getTextWithSubject()); length > botapi.MaxPollQuestionLength {
+			problems = append(problems, fmt.Sprintf("poll question has %d characters, max is %d", length, botapi.MaxPollQuestionLength))
+		}
+		for key, option := range t.Options {
+			if length := botapi.TextLength(option); length == 0 || length > botapi.MaxPollOptionLength {
+				problems = append(problems, fmt.Sprintf("poll option %s has %d characters, max is %d", key, length, botapi.MaxPollOptionLength))
+			}
+		}
+		if answer, found := t.Options[t.Answer]; found {
+			if length := botapi.TextLength(ExplanationPrefix + answer); length > botapi.MaxPollExplanationLength {
+				problems = append(problems, fmt.Sprintf("poll explanation has %d characters, max is %d", length, botapi.MaxPollExplanationLength))
+			}
+		}
+		return problems
+	}
+	text := render.HTML(t.renderMessage(t.getTextWithSubject(), t.sortedOptionKeys()))
+	if err := botapi.CheckHTML(text); err != nil {
+		problems = append(problems, fmt.Sprintf("message is not valid HTML: %v", err))
+	} else if length := botapi.HTMLTextLength(text); length > botapi.MaxMessageLength {
+		problems = append(problems, fmt.Sprintf("message has %d characters, max is %d", length, botapi.MaxMessageLength))
+	}
+	return problems
+}
@@ -0,0 +1,55 @@
+package collection
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTaskProblems(t *testing.T) {
+	longText := strings.Repeat("а", 5000)
+	testCases := []struct {
+		name     string
+		task     *Task
+		expected []string
+	}{
+		{
+			name:     "valid",
+			task:     &Task{Level: LevelLow, Text: "a", Answer: "1", Options: map[string]string{"1": "1", "2": "2"}},
+			expected: []string{},
+		},
+		{
+			name: "every broken field",
+			task: &Task{Level: 9, Text: "a", Answer: "2", Options: map[string]string{"1": "1"}},
+			expected: []string{
+				"unknown level 9",
+				"1 options, min is 2",
+				`answer "2" is not among options`,
+			},
+		},
+		{
+			name: "long message of broken task",
+			task: &Task{Level: 9, Text: longText, Answer: "1", Options: map[string]string{"1": "1", "2": "2"}},
+			expected: []string{
+				"unknown level 9",
+				"message has 5018 characters, max is 4096",
+			},
+		},
+		{
+			name: "long poll option of broken task",
+			task: &Task{Level: LevelLow, Text: "a", Answer: "3", Options: map[string]string{"1": "1", "2": longText[:300]}, SendAsPoll: true},
+			expected: []string{
+				`answer "3" is not among options`,
+				"poll option 2 has 150 characters, max is 100",
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			testCase.task.SubjectName = "Физика"
+			if problems := testCase.task.problems(); !reflect.DeepEqual(problems, testCase.expected) {
+				t.Errorf("expected %q, got %q", testCase.expected, problems)
+			}
+		})
+	}
+}
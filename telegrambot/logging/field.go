@@ -0,0 +1,75 @@
+package logging
+
+import (
+	"time"
+)
+
+// ErrorKey is the key of fields made by Err.
+const ErrorKey = "error"
+
+type fieldKind int
+
+const (
+	kindPlain fieldKind = iota
+	kindUserID
+	kindUserName
+	kindTaskBody
+)
+
+// Field is a key and a value added to a log line.
+type Field struct {
+	Key   string
+	Value interface{}
+	kind  fieldKind
+}
+
+func String(key, value string) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int(key string, value int) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Int64(key string, value int64) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Bool(key string, value bool) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Duration(key string, value time.Duration) Field {
+	return Field{Key: key, Value: value.String()}
+}
+
+func Any(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+func Err(err error) Field {
+	if err == nil {
+		return Field{Key: ErrorKey, Value: nil}
+	}
+	return Field{Key: ErrorKey, Value: err.Error()}
+}
+
+// UserID is the ID of a Telegram user.
+func UserID(id int) Field {
+	return Field{Key: "userId", Value: int64(id), kind: kindUserID}
+}
+
+// ChatID is the ID of a Telegram chat, which is the user ID for private chats.
+func ChatID(id int64) Field {
+	return Field{Key: "chatId", Value: id, kind: kindUserID}
+}
+
+// UserName is a first, last or user name of a Telegram user.
+func UserName(name string) Field {
+	return Field{Key: "userName", Value: name, kind: kindUserName}
+}
+
+// TaskBody is a text, options or a whole rendering of a task.
+func TaskBody(key string, body interface{}) Field {
+	return Field{Key: key, Value: body, kind: kindTaskBody}
+}
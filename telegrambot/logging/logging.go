@@ -0,0 +1,185 @@
+// Package logging writes leveled log lines as JSON objects. Fields holding
+// personal data of users or task contents are redacted according to a policy.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level name, empty name means info.
+func ParseLevel(name string) (Level, error) {
+	if name == "" {
+		return LevelInfo, nil
+	}
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(level), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q", name)
+}
+
+// Logger writes log lines with its fields and fields of every call. It is safe
+// for concurrent use.
+type Logger struct {
+	mutex  *sync.Mutex
+	out    io.Writer
+	level  Level
+	policy Policy
+	fields []Field
+}
+
+func New(out io.Writer, level Level, policy Policy) *Logger {
+	if policy.salt == "" {
+		policy.salt = newSalt()
+	}
+	return &Logger{
+		mutex:  &sync.Mutex{},
+		out:    out,
+		level:  level,
+		policy: policy,
+	}
+}
+
+// With returns a logger which adds the fields to every line.
+func (l *Logger) With(fields ...Field) *Logger {
+	child := *l
+	child.fields = append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+	return &child
+}
+
+func (l *Logger) Debug(message string, fields ...Field) {
+	l.write(LevelDebug, message, fields)
+}
+
+func (l *Logger) Info(message string, fields ...Field) {
+	l.write(LevelInfo, message, fields)
+}
+
+func (l *Logger) Warn(message string, fields ...Field) {
+	l.write(LevelWarn, message, fields)
+}
+
+func (l *Logger) Error(message string, fields ...Field) {
+	l.write(LevelError, message, fields)
+}
+
+// Panic writes an error line and panics with the message and the fields.
+func (l *Logger) Panic(message string, fields ...Field) {
+	l.write(LevelError, message, fields)
+	panic(l.Format(message, fields...))
+}
+
+// Format renders the message with the fields as plain text, e.g. for alerts.
+// Fields are redacted the same way as in log lines.
+func (l *Logger) Format(message string, fields ...Field) string {
+	pairs := make([]string, 0, len(l.fields)+len(fields))
+	for _, field := range l.allFields(fields) {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", field.Key, l.policy.redact(field)))
+	}
+	if len(pairs) == 0 {
+		return message
+	}
+	return fmt.Sprintf("%s (%s)", message, strings.Join(pairs, ", "))
+}
+
+func (l *Logger) allFields(fields []Field) []Field {
+	if len(l.fields) == 0 {
+		return fields
+	}
+	return append(append(make([]Field, 0, len(l.fields)+len(fields)), l.fields...), fields...)
+}
+
+func (l *Logger) write(level Level, message string, fields []Field) {
+	if level < l.level {
+		return
+	}
+	var line bytes.Buffer
+	line.WriteString(`{"time":`)
+	writeJSON(&line, time.Now().UTC().Format(time.RFC3339Nano))
+	line.WriteString(`,"level":`)
+	writeJSON(&line, level.String())
+	line.WriteString(`,"message":`)
+	writeJSON(&line, message)
+	for _, field := range l.allFields(fields) {
+		line.WriteByte(',')
+		writeJSON(&line, field.Key)
+		line.WriteByte(':')
+		writeJSON(&line, l.policy.redact(field))
+	}
+	line.WriteString("}\n")
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, _ = l.out.Write(line.Bytes())
+}
+
+func writeJSON(buffer *bytes.Buffer, value interface{}) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprintf("%v", value))
+	}
+	buffer.Write(data)
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(New(os.Stderr, LevelInfo, DefaultPolicy()))
+}
+
+// Default returns the logger used by package level functions.
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+// SetDefault replaces the logger used by package level functions.
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+func Debug(message string, fields ...Field) {
+	Default().Debug(message, fields...)
+}
+
+func Info(message string, fields ...Field) {
+	Default().Info(message, fields...)
+}
+
+func Warn(message string, fields ...Field) {
+	Default().Warn(message, fields...)
+}
+
+func Error(message string, fields ...Field) {
+	Default().Error(message, fields...)
+}
+
+func Panic(message string, fields ...Field) {
+	Default().Panic(message, fields...)
+}
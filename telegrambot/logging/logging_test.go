@@ -0,0 +1,100 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := New(&buffer, LevelInfo, Policy{}).With(Int("updateId", 7))
+	logger.Debug("Hidden")
+	logger.Info("Update handled", UserID(42), UserName("Ivan @ivan"), Err(errors.New("failed")))
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected debug line to be skipped, got %q", lines)
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"level":    "info",
+		"message":  "Update handled",
+		"updateId": 7.0,
+		"userId":   42.0,
+		"userName": "Ivan @ivan",
+		"error":    "failed",
+	}
+	for key, value := range expected {
+		if line[key] != value {
+			t.Errorf("expected %s to be %v, got %v", key, value, line[key])
+		}
+	}
+	if _, found := line["time"]; !found {
+		t.Errorf("expected time in %v", line)
+	}
+}
+
+func TestRedaction(t *testing.T) {
+	var buffer bytes.Buffer
+	logger := New(&buffer, LevelDebug, DefaultPolicy())
+	fields := []Field{UserID(42), ChatID(42), UserName("Ivan"), TaskBody("task", `{"text": "2 + 2"}`), Int("taskId", 1)}
+	logger.Info("Make message", fields...)
+
+	output := buffer.String()
+	for _, secret := range []string{`":42`, "Ivan", "2 + 2"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("expected %q to be redacted in %s", secret, output)
+		}
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal(buffer.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if line["userId"] != line["chatId"] {
+		t.Errorf("expected user and chat IDs of one user to have one hash, got %v and %v", line["userId"], line["chatId"])
+	}
+	if line["taskId"] != 1.0 {
+		t.Errorf("expected task ID to be kept, got %v", line["taskId"])
+	}
+
+	text := logger.Format("Make message", fields...)
+	if strings.Contains(text, "Ivan") || !strings.HasPrefix(text, "Make message (userId=h:") {
+		t.Errorf("unexpected formatted text %q", text)
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	for list, expected := range map[string]Policy{
+		"":                     DefaultPolicy(),
+		"all":                  DefaultPolicy(),
+		"none":                 {},
+		"user_names, user_ids": {UserNames: true, UserIDs: true},
+		"task_bodies":          {TaskBodies: true},
+	} {
+		policy, err := ParsePolicy(list)
+		if err != nil || policy != expected {
+			t.Errorf("ParsePolicy(%q) = %+v, %v, expected %+v", list, policy, err, expected)
+		}
+	}
+	if _, err := ParsePolicy("emails"); err == nil {
+		t.Error("expected error on unknown redaction")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel("DEBUG"); err != nil || level != LevelDebug {
+		t.Errorf("unexpected level %v, %v", level, err)
+	}
+	if level, err := ParseLevel(""); err != nil || level != LevelInfo {
+		t.Errorf("unexpected default level %v, %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected error on unknown level")
+	}
+}
@@ -0,0 +1,95 @@
+package logging
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	redactUserNames  = "user_names"
+	redactUserIDs    = "user_ids"
+	redactTaskBodies = "task_bodies"
+	redactAll        = "all"
+	redactNone       = "none"
+
+	redactedValue = "[redacted]"
+)
+
+// Policy tells which fields are redacted.
+type Policy struct {
+	// UserNames hides first, last and user names.
+	UserNames bool
+	// UserIDs replaces user and chat IDs with a salted hash, so lines of one
+	// user can still be linked within one run of the bot.
+	UserIDs bool
+	// TaskBodies hides texts and options of tasks, their IDs are kept.
+	TaskBodies bool
+
+	salt string
+}
+
+// DefaultPolicy redacts everything.
+func DefaultPolicy() Policy {
+	return Policy{UserNames: true, UserIDs: true, TaskBodies: true}
+}
+
+// ParsePolicy parses a comma separated list of what to redact: user_names,
+// user_ids and task_bodies, or all or none. Empty list means all.
+func ParsePolicy(list string) (Policy, error) {
+	list = strings.TrimSpace(list)
+	if list == "" || list == redactAll {
+		return DefaultPolicy(), nil
+	}
+	var policy Policy
+	if list == redactNone {
+		return policy, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		switch strings.TrimSpace(item) {
+		case redactUserNames:
+			policy.UserNames = true
+		case redactUserIDs:
+			policy.UserIDs = true
+		case redactTaskBodies:
+			policy.TaskBodies = true
+		default:
+			return policy, fmt.Errorf("unknown redaction %q", item)
+		}
+	}
+	return policy, nil
+}
+
+func (p Policy) redact(field Field) interface{} {
+	switch field.kind {
+	case kindUserID:
+		if p.UserIDs {
+			return p.hashID(field.Value.(int64))
+		}
+	case kindUserName:
+		if p.UserNames && field.Value != "" {
+			return redactedValue
+		}
+	case kindTaskBody:
+		if p.TaskBodies {
+			return redactedValue
+		}
+	}
+	return field.Value
+}
+
+func (p Policy) hashID(id int64) string {
+	hash := sha256.Sum256([]byte(p.salt + strconv.FormatInt(id, 10)))
+	return "h:" + hex.EncodeToString(hash[:6])
+}
+
+func newSalt() string {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return string(salt)
+}
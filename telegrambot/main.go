@@ -2,99 +2,231 @@ package main
 
 import (
 	"flag"
-	"log"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ravil23/usebot/telegrambot/alert"
 	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/logging"
+	"github.com/ravil23/usebot/telegrambot/state"
 	"github.com/ravil23/usebot/telegrambot/telegram"
 )
 
-var russianSubjectPath string
-var mathAdvancedSubjectPath string
-var mathBasicSubjectPath string
-var physicsSubjectPath string
-var chemistrySubjectPath string
-var itSubjectPath string
-var biologySubjectPath string
-var historySubjectPath string
-var geographySubjectPath string
-var englishSubjectPath string
-var germanSubjectPath string
-var frenchSubjectPath string
-var socialSubjectPath string
-var spanishSubjectPath string
-var literatureSubjectPath string
-var test bool
+const (
+	commandValidate = "validate"
+
+	alertSinkTelegram = "telegram"
+	alertSinkWebhook  = "webhook"
+	alertSinkStdout   = "stdout"
+)
+
+var subjectsConfigPath string
+var statePath string
+var botAPIEndpoint string
+var webhook telegram.WebhookConfig
+var dryRunReportPath string
+var logLevel string
+var logRedaction string
+var alertSinks string
+var alertWebhookURL string
+var alertWindow string
+var alertLimit string
+var adminUserIDs string
 
 func init() {
-	russianSubjectPath = os.Getenv("SUBJECT_RUSSIAN")
-	mathAdvancedSubjectPath = os.Getenv("SUBJECT_MATH_ADVANCED")
-	mathBasicSubjectPath = os.Getenv("SUBJECT_MATH_BASIC")
-	physicsSubjectPath = os.Getenv("SUBJECT_PHYSICS")
-	chemistrySubjectPath = os.Getenv("SUBJECT_CHEMISTRY")
-	itSubjectPath = os.Getenv("SUBJECT_IT")
-	biologySubjectPath = os.Getenv("SUBJECT_BIOLOGY")
-	historySubjectPath = os.Getenv("SUBJECT_HISTORY")
-	geographySubjectPath = os.Getenv("SUBJECT_GEOGRAPHY")
-	englishSubjectPath = os.Getenv("SUBJECT_ENGLISH")
-	germanSubjectPath = os.Getenv("SUBJECT_GERMAN")
-	frenchSubjectPath = os.Getenv("SUBJECT_FRENCH")
-	socialSubjectPath = os.Getenv("SUBJECT_SOCIAL")
-	spanishSubjectPath = os.Getenv("SUBJECT_SPANISH")
-	literatureSubjectPath = os.Getenv("SUBJECT_LITERATURE")
-	flag.BoolVar(&test, "test", false, "Send all tasks to alerts channel for testing")
+	subjectsConfigPath = os.Getenv("SUBJECTS_CONFIG")
+	statePath = os.Getenv("STATE_PATH")
+	botAPIEndpoint = os.Getenv("BOT_API_ENDPOINT")
+	logLevel = os.Getenv("LOG_LEVEL")
+	logRedaction = os.Getenv("LOG_REDACT")
+	alertSinks = os.Getenv("ALERT_SINKS")
+	alertWebhookURL = os.Getenv("ALERT_WEBHOOK_URL")
+	alertWindow = os.Getenv("ALERT_WINDOW")
+	alertLimit = os.Getenv("ALERT_LIMIT")
+	adminUserIDs = os.Getenv("ADMIN_USER_IDS")
+	webhook = telegram.WebhookConfig{
+		URL:         os.Getenv("WEBHOOK_URL"),
+		SecretToken: os.Getenv("WEBHOOK_SECRET_TOKEN"),
+		CertPath:    os.Getenv("WEBHOOK_CERT_PATH"),
+		KeyPath:     os.Getenv("WEBHOOK_KEY_PATH"),
+	}
+	flag.StringVar(&dryRunReportPath, "dry-run", "", "Render all tasks against a fake Telegram API and write a report to the file (- for stdout)")
 }
 
 func parseArguments() {
 	flag.Parse()
-	if russianSubjectPath == "" ||
-		mathAdvancedSubjectPath == "" ||
-		mathBasicSubjectPath == "" ||
-		physicsSubjectPath == "" ||
-		chemistrySubjectPath == "" ||
-		itSubjectPath == "" ||
-		biologySubjectPath == "" ||
-		historySubjectPath == "" ||
-		geographySubjectPath == "" ||
-		englishSubjectPath == "" ||
-		germanSubjectPath == "" ||
-		frenchSubjectPath == "" ||
-		socialSubjectPath == "" ||
-		spanishSubjectPath == "" ||
-		literatureSubjectPath == "" {
-		log.Printf("Some of subject paths are empty")
+	setUpLogging()
+	if flag.Arg(0) == commandValidate && flag.Arg(1) != "" {
+		subjectsConfigPath = flag.Arg(1)
+	}
+	if subjectsConfigPath == "" {
+		logging.Error("Subjects config path is empty")
+		os.Exit(2)
+	}
+}
+
+func setUpLogging() {
+	level, err := logging.ParseLevel(logLevel)
+	if err != nil {
+		logging.Error("Invalid LOG_LEVEL", logging.Err(err))
+		os.Exit(2)
+	}
+	policy, err := logging.ParsePolicy(logRedaction)
+	if err != nil {
+		logging.Error("Invalid LOG_REDACT", logging.Err(err))
 		os.Exit(2)
 	}
+	logging.SetDefault(logging.New(os.Stderr, level, policy))
 }
 
 func main() {
 	parseArguments()
+	if flag.Arg(0) == commandValidate {
+		validate()
+		return
+	}
+
+	loader, err := collection.NewLoader(subjectsConfigPath)
+	if err != nil {
+		logging.Panic("Error on loading database", logging.Err(err))
+	}
+	loader.Database().Show()
 
-	database := collection.NewDatabase(
-		russianSubjectPath,
-		mathAdvancedSubjectPath,
-		mathBasicSubjectPath,
-		physicsSubjectPath,
-		chemistrySubjectPath,
-		itSubjectPath,
-		biologySubjectPath,
-		historySubjectPath,
-		geographySubjectPath,
-		englishSubjectPath,
-		germanSubjectPath,
-		frenchSubjectPath,
-		socialSubjectPath,
-		spanishSubjectPath,
-		literatureSubjectPath,
-	)
-	database.Show()
-
-	bot := telegram.NewBot(database)
-	bot.Init()
-	if test {
-		bot.TestAllTasks(telegram.AlertsChatID)
+	if dryRunReportPath != "" {
+		if failuresCount := dryRun(telegram.NewBot(loader, state.NewMemoryStore())); failuresCount > 0 {
+			logging.Error("Dry run found failures", logging.Int("failures", failuresCount), logging.String("report", dryRunReportPath))
+			os.Exit(1)
+		}
+		logging.Info("Dry run succeeded")
 		return
 	}
+
+	store := newStoreOrPanic()
+	defer func() {
+		if err := store.Close(); err != nil {
+			logging.Error("Error on closing state store", logging.Err(err))
+		}
+	}()
+
+	bot := telegram.NewBot(loader, store)
+	bot.Init(botAPIEndpoint)
+	useAlerterOrPanic(bot)
+	bot.UseAdmins(parseAdminUserIDsOrPanic())
+	if webhook.URL != "" {
+		if err := bot.UseWebhook(webhook); err != nil {
+			logging.Panic("Invalid webhook config", logging.Err(err))
+		}
+	}
 	bot.HealthCheck()
-	bot.Run()
+	if !bot.Run() {
+		// Every write to the store is synced, so it is left open for handlers still
+		// running rather than closed under them.
+		logging.Error("Bot stopped with handlers still running, state store is not closed")
+		os.Exit(1)
+	}
+}
+
+// useAlerterOrPanic configures where alerts are sent, by default to the Telegram
+// alerts chat only.
+func useAlerterOrPanic(bot *telegram.Bot) {
+	if alertSinks == "" {
+		alertSinks = alertSinkTelegram
+	}
+	var sinks alert.Multi
+	for _, name := range strings.Split(alertSinks, ",") {
+		switch strings.TrimSpace(name) {
+		case alertSinkTelegram:
+			sinks = append(sinks, bot.TelegramAlerter())
+		case alertSinkWebhook:
+			if alertWebhookURL == "" {
+				logging.Panic("Alert webhook URL is empty")
+			}
+			sinks = append(sinks, alert.NewWebhook(alertWebhookURL))
+		case alertSinkStdout:
+			sinks = append(sinks, alert.NewWriter(os.Stdout))
+		default:
+			logging.Panic("Unknown alert sink", logging.String("sink", name))
+		}
+	}
+
+	config := alert.DefaultThrottleConfig
+	if alertWindow != "" {
+		window, err := time.ParseDuration(alertWindow)
+		if err != nil || window <= 0 {
+			logging.Panic("Invalid alert window", logging.String("window", alertWindow))
+		}
+		config.Window = window
+	}
+	if alertLimit != "" {
+		limit, err := strconv.Atoi(alertLimit)
+		if err != nil || limit <= 0 {
+			logging.Panic("Invalid alert limit", logging.String("limit", alertLimit))
+		}
+		config.Limit = limit
+	}
+	bot.UseAlerter(sinks, config)
+}
+
+// parseAdminUserIDsOrPanic parses the comma separated list of users who may run
+// admin commands.
+func parseAdminUserIDsOrPanic() []int {
+	if adminUserIDs == "" {
+		logging.Warn("Admin user IDs are empty, admin commands are disabled")
+		return nil
+	}
+	var userIDs []int
+	for _, value := range strings.Split(adminUserIDs, ",") {
+		userID, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			logging.Panic("Invalid admin user ID", logging.String("userId", value))
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs
+}
+
+func newStoreOrPanic() state.Store {
+	if statePath == "" {
+		logging.Warn("State path is empty, user state will be kept in memory only")
+		return state.NewMemoryStore()
+	}
+	store, err := state.NewFileStore(statePath)
+	if err != nil {
+		logging.Panic("Error on opening state store", logging.Err(err))
+	}
+	return store
+}
+
+// validate prints all problems of task data and exits with non-zero code if any.
+func validate() {
+	config, err := collection.ParseConfigFile(subjectsConfigPath)
+	if err != nil {
+		logging.Error("Invalid subjects config", logging.Err(err))
+		os.Exit(2)
+	}
+	problems := collection.Validate(config)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		logging.Error("Found problems", logging.Int("problems", len(problems)))
+		os.Exit(1)
+	}
+	logging.Info("No problems found")
+}
+
+func dryRun(bot *telegram.Bot) int {
+	report := os.Stdout
+	if dryRunReportPath != "-" {
+		file, err := os.Create(dryRunReportPath)
+		if err != nil {
+			logging.Panic("Error on creating dry run report", logging.Err(err))
+		}
+		defer file.Close()
+		report = file
+	}
+	return bot.DryRun(report)
 }
@@ -0,0 +1,262 @@
+// Package metrics keeps counters, histograms and gauges and writes them in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+// DefaultBuckets are upper bounds of histogram buckets in seconds, which suit
+// latency of handlers and Telegram requests.
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type collector interface {
+	write(w io.Writer)
+}
+
+// Registry is a set of metrics served together.
+type Registry struct {
+	mutex      sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(c collector) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes all metrics in the order they were registered.
+func (r *Registry) Write(w io.Writer) {
+	r.mutex.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mutex.Unlock()
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.Write(w)
+}
+
+// vec keeps values of a metric by label values.
+type vec struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	mutex      sync.Mutex
+	values     map[string]interface{}
+	newValue   func() interface{}
+}
+
+// get returns the value for the label values. A wrong number of label values is
+// a bug of the caller, it is logged and the sample must be dropped.
+func (v *vec) get(labelValues []string) (interface{}, bool) {
+	if len(labelValues) != len(v.labelNames) {
+		logging.Error("Metric sample is dropped, wrong number of label values", logging.String("metric", v.name), logging.Int("expected", len(v.labelNames)), logging.Int("got", len(labelValues)))
+		return nil, false
+	}
+	key := strings.Join(labelValues, "\xff")
+	value, found := v.values[key]
+	if !found {
+		value = v.newValue()
+		v.values[key] = value
+	}
+	return value, true
+}
+
+func (v *vec) writeHeader(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, v.help, v.name, v.kind)
+}
+
+// sortedKeys returns keys of values sorted to make the output stable.
+func (v *vec) sortedKeys() []string {
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (v *vec) formatLabels(key string, extra ...string) string {
+	pairs := make([]string, 0, len(v.labelNames)+1)
+	if len(v.labelNames) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, fmt.Sprintf("%s=%s", v.labelNames[i], strconv.Quote(value)))
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", extra[i], strconv.Quote(extra[i+1])))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+type CounterVec struct {
+	vec
+}
+
+func (r *Registry) NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	c := &CounterVec{vec{
+		name:       name,
+		help:       help,
+		kind:       "counter",
+		labelNames: labelNames,
+		values:     make(map[string]interface{}),
+		newValue:   func() interface{} { return new(float64) },
+	}}
+	r.register(c)
+	return c
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if counter, ok := c.get(labelValues); ok {
+		*counter.(*float64) += value
+	}
+}
+
+// Value returns the current value of the counter, mostly for tests.
+func (c *CounterVec) Value(labelValues ...string) float64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	counter, ok := c.get(labelValues)
+	if !ok {
+		return 0
+	}
+	return *counter.(*float64)
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.writeHeader(w)
+	for _, key := range c.sortedKeys() {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", c.name, c.formatLabels(key), formatFloat(*c.values[key].(*float64)))
+	}
+}
+
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type HistogramVec struct {
+	vec
+	buckets []float64
+}
+
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	h := &HistogramVec{
+		vec: vec{
+			name:       name,
+			help:       help,
+			kind:       "histogram",
+			labelNames: labelNames,
+			values:     make(map[string]interface{}),
+		},
+		buckets: buckets,
+	}
+	h.newValue = func() interface{} { return &histogram{counts: make([]uint64, len(buckets))} }
+	r.register(h)
+	return h
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	sample, ok := h.get(labelValues)
+	if !ok {
+		return
+	}
+	values := sample.(*histogram)
+	for i, bound := range h.buckets {
+		if value <= bound {
+			values.counts[i]++
+		}
+	}
+	values.sum += value
+	values.count++
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.writeHeader(w)
+	for _, key := range h.sortedKeys() {
+		values := h.values[key].(*histogram)
+		for i, bound := range h.buckets {
+			_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(key, "le", formatFloat(bound)), values.counts[i])
+		}
+		_, _ = fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.formatLabels(key, "le", "+Inf"), values.count)
+		_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.formatLabels(key), formatFloat(values.sum))
+		_, _ = fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.formatLabels(key), values.count)
+	}
+}
+
+// GaugeFunc is a gauge which value is computed on every scrape.
+type GaugeFunc struct {
+	vec
+	value func() map[string]float64
+}
+
+// NewGaugeFunc registers a gauge with at most one label. The function returns
+// values by label value, or by the empty string if there is no label. A gauge
+// with more labels is logged and never written.
+func (r *Registry) NewGaugeFunc(name, help string, value func() map[string]float64, labelNames ...string) *GaugeFunc {
+	g := &GaugeFunc{
+		vec:   vec{name: name, help: help, kind: "gauge", labelNames: labelNames},
+		value: value,
+	}
+	if len(labelNames) > 1 {
+		logging.Error("Gauge is dropped, it may have at most one label", logging.String("metric", name), logging.Int("labels", len(labelNames)))
+		return g
+	}
+	r.register(g)
+	return g
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	values := g.value()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	g.writeHeader(w)
+	for _, key := range keys {
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", g.name, g.formatLabels(key), formatFloat(values[key]))
+	}
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Requests.", "method", "code")
+	counter.Inc("get", "200")
+	counter.Add(2, "post", "500")
+	counter.Inc("get", "200")
+	histogram := registry.NewHistogramVec("latency_seconds", "Latency.", []float64{0.1, 1}, "type")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(5, "a")
+	registry.NewGaugeFunc("users", "Users.", func() map[string]float64 {
+		return map[string]float64{"": 3}
+	})
+
+	var buffer bytes.Buffer
+	registry.Write(&buffer)
+	expected := `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{method="get",code="200"} 2
+requests_total{method="post",code="500"} 2
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{type="a",le="0.1"} 1
+latency_seconds_bucket{type="a",le="1"} 2
+latency_seconds_bucket{type="a",le="+Inf"} 3
+latency_seconds_sum{type="a"} 5.55
+latency_seconds_count{type="a"} 3
+# HELP users Users.
+# TYPE users gauge
+users 3
+`
+	if buffer.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+}
+
+func TestWrongLabelsCount(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Requests.", "method")
+	histogram := registry.NewHistogramVec("request_duration_seconds", "Duration.", []float64{1}, "method")
+	registry.NewGaugeFunc("users", "Users.", func() map[string]float64 {
+		return map[string]float64{"": 1}
+	}, "window", "subject")
+
+	counter.Inc("get", "200")
+	histogram.Observe(0.5)
+	counter.Inc("get")
+	if value := counter.Value("get", "200"); value != 0 {
+		t.Errorf("expected sample with wrong labels count to be dropped, got %v", value)
+	}
+	var buffer bytes.Buffer
+	registry.Write(&buffer)
+	expected := "# HELP requests_total Requests.\n" +
+		"# TYPE requests_total counter\n" +
+		"requests_total{method=\"get\"} 1\n" +
+		"# HELP request_duration_seconds Duration.\n" +
+		"# TYPE request_duration_seconds histogram\n"
+	if buffer.String() != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, buffer.String())
+	}
+}
+
+func TestConcurrentScrapes(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounterVec("requests_total", "Requests.", "method")
+	registry.NewGaugeFunc("users", "Users.", func() map[string]float64 {
+		return map[string]float64{"1h": 1, "24h": 2}
+	}, "window")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				counter.Inc("get")
+				recorder := httptest.NewRecorder()
+				registry.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+				if !bytes.Contains(recorder.Body.Bytes(), []byte(`users{window="24h"} 2`)) {
+					t.Errorf("unexpected output:\n%s", recorder.Body.String())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
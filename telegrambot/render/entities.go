@@ -0,0 +1,71 @@
+package render
+
+import (
+	"sort"
+	"unicode/utf16"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// FromEntities restores the tree of a received message from its text and
+// entities. Entities which are not markup, like mentions, are kept as text, as
+// well as entities overlapping others.
+func FromEntities(text string, entities []tgbotapi.MessageEntity) Node {
+	units := utf16.Encode([]rune(text))
+	markup := make([]tgbotapi.MessageEntity, 0, len(entities))
+	for _, entity := range entities {
+		if _, found := entityTags[entity.Type]; found && entity.Offset >= 0 && entity.Length > 0 && entity.Offset+entity.Length <= len(units) {
+			markup = append(markup, entity)
+		}
+	}
+	sort.SliceStable(markup, func(i, j int) bool {
+		if markup[i].Offset != markup[j].Offset {
+			return markup[i].Offset < markup[j].Offset
+		}
+		return markup[i].Length > markup[j].Length
+	})
+	return buildFromEntities(units, 0, len(units), markup)
+}
+
+var entityTags = map[string]func(entity tgbotapi.MessageEntity, children ...Node) Node{
+	"bold":          func(_ tgbotapi.MessageEntity, children ...Node) Node { return Bold(children...) },
+	"italic":        func(_ tgbotapi.MessageEntity, children ...Node) Node { return Italic(children...) },
+	"underline":     func(_ tgbotapi.MessageEntity, children ...Node) Node { return Underline(children...) },
+	"strikethrough": func(_ tgbotapi.MessageEntity, children ...Node) Node { return Strikethrough(children...) },
+	"code":          func(_ tgbotapi.MessageEntity, children ...Node) Node { return Code(children...) },
+	"pre":           func(_ tgbotapi.MessageEntity, children ...Node) Node { return Pre(children...) },
+	"text_link":     func(entity tgbotapi.MessageEntity, children ...Node) Node { return Link(entity.URL, children...) },
+}
+
+// buildFromEntities builds the part of text between start and end. Entities
+// are sorted and lie within the part.
+func buildFromEntities(units []uint16, start, end int, entities []tgbotapi.MessageEntity) Fragment {
+	fragment := make(Fragment, 0)
+	position := start
+	for i := 0; i < len(entities); i++ {
+		entity := entities[i]
+		entityEnd := entity.Offset + entity.Length
+		if entity.Offset < position || entityEnd > end {
+			continue
+		}
+		if entity.Offset > position {
+			fragment = append(fragment, decode(units[position:entity.Offset]))
+		}
+		nestedEnd := i + 1
+		for nestedEnd < len(entities) && entities[nestedEnd].Offset < entityEnd {
+			nestedEnd++
+		}
+		children := buildFromEntities(units, entity.Offset, entityEnd, entities[i+1:nestedEnd])
+		fragment = append(fragment, entityTags[entity.Type](entity, children...))
+		position = entityEnd
+		i = nestedEnd - 1
+	}
+	if position < end {
+		fragment = append(fragment, decode(units[position:end]))
+	}
+	return fragment
+}
+
+func decode(units []uint16) Text {
+	return Text(utf16.Decode(units))
+}
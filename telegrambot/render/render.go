@@ -0,0 +1,122 @@
+// Package render builds texts of Telegram messages in HTML parse mode from a
+// small tree of nodes. Text nodes are always escaped and tags are always
+// balanced, so content with <, > or & can't break a message.
+package render
+
+import (
+	"strings"
+)
+
+// Node is a part of a message.
+type Node interface {
+	writeHTML(builder *strings.Builder)
+	writeText(builder *strings.Builder)
+}
+
+// htmlEscaper escapes only characters which Telegram requires to be escaped,
+// so the HTML stays readable.
+var htmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+var attributeEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+
+// Text is plain text, which is escaped in HTML.
+type Text string
+
+func (t Text) writeHTML(builder *strings.Builder) {
+	_, _ = htmlEscaper.WriteString(builder, string(t))
+}
+
+func (t Text) writeText(builder *strings.Builder) {
+	builder.WriteString(string(t))
+}
+
+// Fragment is a sequence of nodes.
+type Fragment []Node
+
+func (f Fragment) writeHTML(builder *strings.Builder) {
+	for _, node := range f {
+		node.writeHTML(builder)
+	}
+}
+
+func (f Fragment) writeText(builder *strings.Builder) {
+	for _, node := range f {
+		node.writeText(builder)
+	}
+}
+
+// Lines joins the nodes with line breaks.
+func Lines(nodes ...Node) Fragment {
+	fragment := make(Fragment, 0, 2*len(nodes))
+	for i, node := range nodes {
+		if i > 0 {
+			fragment = append(fragment, Text("\n"))
+		}
+		fragment = append(fragment, node)
+	}
+	return fragment
+}
+
+type element struct {
+	tag      string
+	href     string
+	children Fragment
+}
+
+func (e element) writeHTML(builder *strings.Builder) {
+	builder.WriteString("<" + e.tag)
+	if e.href != "" {
+		builder.WriteString(` href="`)
+		_, _ = attributeEscaper.WriteString(builder, e.href)
+		builder.WriteString(`"`)
+	}
+	builder.WriteString(">")
+	e.children.writeHTML(builder)
+	builder.WriteString("</" + e.tag + ">")
+}
+
+func (e element) writeText(builder *strings.Builder) {
+	e.children.writeText(builder)
+}
+
+func Bold(children ...Node) Node {
+	return element{tag: "b", children: children}
+}
+
+func Italic(children ...Node) Node {
+	return element{tag: "i", children: children}
+}
+
+func Underline(children ...Node) Node {
+	return element{tag: "u", children: children}
+}
+
+func Strikethrough(children ...Node) Node {
+	return element{tag: "s", children: children}
+}
+
+func Code(children ...Node) Node {
+	return element{tag: "code", children: children}
+}
+
+func Pre(children ...Node) Node {
+	return element{tag: "pre", children: children}
+}
+
+func Link(url string, children ...Node) Node {
+	return element{tag: "a", href: url, children: children}
+}
+
+// HTML renders the node for HTML parse mode.
+func HTML(node Node) string {
+	var builder strings.Builder
+	node.writeHTML(&builder)
+	return builder.String()
+}
+
+// PlainText renders the node without markup, the way users see it.
+func PlainText(node Node) string {
+	var builder strings.Builder
+	node.writeText(&builder)
+	return builder.String()
+}
@@ -0,0 +1,82 @@
+package render
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestHTML(t *testing.T) {
+	node := Lines(
+		Bold(Text("a < b && c > d")),
+		Fragment{Text("see "), Link(`https://example.com/?a=1&b="2"`, Italic(Text("<doc>")))},
+		Pre(Code(Text("if (x < 1) {}"))),
+	)
+	expected := "<b>a &lt; b &amp;&amp; c &gt; d</b>\n" +
+		`see <a href="https://example.com/?a=1&amp;b=&quot;2&quot;"><i>&lt;doc&gt;</i></a>` + "\n" +
+		"<pre><code>if (x &lt; 1) {}</code></pre>"
+	if html := HTML(node); html != expected {
+		t.Errorf("expected %q, got %q", expected, html)
+	}
+	if text := PlainText(node); text != "a < b && c > d\nsee <doc>\nif (x < 1) {}" {
+		t.Errorf("unexpected plain text %q", text)
+	}
+}
+
+func TestFromEntities(t *testing.T) {
+	testCases := []struct {
+		name     string
+		text     string
+		entities []tgbotapi.MessageEntity
+		expected string
+	}{
+		{
+			name:     "no entities",
+			text:     "1 < 2 & 3",
+			expected: "1 &lt; 2 &amp; 3",
+		},
+		{
+			name: "nested",
+			text: "bold italic plain",
+			entities: []tgbotapi.MessageEntity{
+				{Type: "italic", Offset: 5, Length: 6},
+				{Type: "bold", Offset: 0, Length: 11},
+			},
+			expected: "<b>bold <i>italic</i></b> plain",
+		},
+		{
+			name:     "offsets in UTF-16",
+			text:     "😀 x<y 😀 z",
+			entities: []tgbotapi.MessageEntity{{Type: "code", Offset: 3, Length: 3}},
+			expected: "😀 <code>x&lt;y</code> 😀 z",
+		},
+		{
+			name: "not markup and overlapping",
+			text: "@user abc",
+			entities: []tgbotapi.MessageEntity{
+				{Type: "mention", Offset: 0, Length: 5},
+				{Type: "bold", Offset: 6, Length: 2},
+				{Type: "italic", Offset: 7, Length: 2},
+				{Type: "underline", Offset: 8, Length: 5},
+			},
+			expected: "@user <b>ab</b>c",
+		},
+		{
+			name:     "link",
+			text:     "doc",
+			entities: []tgbotapi.MessageEntity{{Type: "text_link", Offset: 0, Length: 3, URL: "https://fipi.ru"}},
+			expected: `<a href="https://fipi.ru">doc</a>`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			node := FromEntities(testCase.text, testCase.entities)
+			if html := HTML(node); html != testCase.expected {
+				t.Errorf("expected %q, got %q", testCase.expected, html)
+			}
+			if text := PlainText(node); text != testCase.text {
+				t.Errorf("expected plain text %q, got %q", testCase.text, text)
+			}
+		})
+	}
+}
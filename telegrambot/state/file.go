@@ -0,0 +1,343 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// minCompactionSize is how much the journal may grow over twice the size of the
+// last snapshot before it is compacted again.
+const minCompactionSize = 1 << 20
+
+// FileStore is a journal of state changes on disk backed by an in-memory copy.
+// Every change is appended as a single JSON line and synced to disk. The journal
+// is compacted to a snapshot when the store is opened and when it grows too much.
+type FileStore struct {
+	memory *MemoryStore
+
+	mutex   sync.Mutex
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+	// size is the size of the journal and snapshotSize is its size right after
+	// the last compaction.
+	size         int64
+	snapshotSize int64
+	compactErr   error
+}
+
+type record struct {
+	User          *User   `json:"user,omitempty"`
+	Poll          *Poll   `json:"poll,omitempty"`
+	DeletedPollID string  `json:"deletedPollId,omitempty"`
+	Answer        *Answer `json:"answer,omitempty"`
+	Review        *Review `json:"review,omitempty"`
+	DeletedReview *Review `json:"deletedReview,omitempty"`
+
+	TaskFailure        *TaskFailure `json:"taskFailure,omitempty"`
+	DeletedTaskFailure *TaskFailure `json:"deletedTaskFailure,omitempty"`
+}
+
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		memory: NewMemoryStore(),
+		path:   path,
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) LoadUser(userID int) (*User, error) {
+	return s.memory.LoadUser(userID)
+}
+
+func (s *FileStore) SaveUser(user *User) error {
+	return s.write(&record{User: user})
+}
+
+func (s *FileStore) LoadUsersWithExams() ([]User, error) {
+	return s.memory.LoadUsersWithExams()
+}
+
+func (s *FileStore) LoadPoll(pollID string) (*Poll, error) {
+	return s.memory.LoadPoll(pollID)
+}
+
+func (s *FileStore) SavePoll(poll *Poll) error {
+	return s.write(&record{Poll: poll})
+}
+
+func (s *FileStore) DeletePoll(pollID string) error {
+	return s.write(&record{DeletedPollID: pollID})
+}
+
+func (s *FileStore) SaveAnswer(answer *Answer) error {
+	return s.write(&record{Answer: answer})
+}
+
+func (s *FileStore) LoadAnswers(userID int) ([]Answer, error) {
+	return s.memory.LoadAnswers(userID)
+}
+
+func (s *FileStore) LoadReview(userID, taskID int) (*Review, error) {
+	return s.memory.LoadReview(userID, taskID)
+}
+
+func (s *FileStore) LoadReviews(userID int) ([]Review, error) {
+	return s.memory.LoadReviews(userID)
+}
+
+func (s *FileStore) SaveReview(review *Review) error {
+	return s.write(&record{Review: review})
+}
+
+func (s *FileStore) DeleteReview(userID, taskID int) error {
+	return s.write(&record{DeletedReview: &Review{UserID: userID, TaskID: taskID}})
+}
+
+func (s *FileStore) LoadTaskFailure(taskID int) (*TaskFailure, error) {
+	return s.memory.LoadTaskFailure(taskID)
+}
+
+func (s *FileStore) LoadTaskFailures() ([]TaskFailure, error) {
+	return s.memory.LoadTaskFailures()
+}
+
+func (s *FileStore) SaveTaskFailure(failure *TaskFailure) error {
+	return s.write(&record{TaskFailure: failure})
+}
+
+func (s *FileStore) DeleteTaskFailure(taskID int) error {
+	return s.write(&record{DeletedTaskFailure: &TaskFailure{TaskID: taskID}})
+}
+
+func (s *FileStore) Ping() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("state file %s is closed", s.path)
+	}
+	if s.compactErr != nil {
+		return fmt.Errorf("state file %s is not compacted: %v", s.path, s.compactErr)
+	}
+	_, err := s.file.Stat()
+	return err
+}
+
+func (s *FileStore) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+// write appends the record to the journal and applies it to memory. The journal
+// is compacted after the record is applied, so the snapshot includes it.
+func (s *FileStore) write(r *record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.file == nil {
+		return fmt.Errorf("state file %s is closed", s.path)
+	}
+	if err := s.encoder.Encode(r); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.apply(r)
+	if s.size > 2*s.snapshotSize+minCompactionSize {
+		s.reopen()
+	}
+	return nil
+}
+
+// reopen compacts the journal and continues appending to the compacted one.
+// The record is already saved, so failures are reported by Ping only.
+func (s *FileStore) reopen() {
+	file := s.file
+	if err := s.open(); err != nil {
+		s.compactErr = err
+		// Don't retry on every write until the journal grows again.
+		s.snapshotSize = s.size
+		return
+	}
+	s.compactErr = nil
+	_ = file.Close()
+}
+
+// open compacts the journal and opens it for appending.
+func (s *FileStore) open() error {
+	if err := s.compact(); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	s.file = file
+	s.encoder = json.NewEncoder(&countingWriter{writer: file, count: &s.size})
+	s.size = info.Size()
+	s.snapshotSize = info.Size()
+	return nil
+}
+
+type countingWriter struct {
+	writer io.Writer
+	count  *int64
+}
+
+func (w *countingWriter) Write(data []byte) (int, error) {
+	n, err := w.writer.Write(data)
+	*w.count += int64(n)
+	return n, err
+}
+
+func (s *FileStore) apply(r *record) {
+	if r.User != nil {
+		_ = s.memory.SaveUser(r.User)
+	}
+	if r.Poll != nil {
+		_ = s.memory.SavePoll(r.Poll)
+	}
+	if r.DeletedPollID != "" {
+		_ = s.memory.DeletePoll(r.DeletedPollID)
+	}
+	if r.Answer != nil {
+		_ = s.memory.SaveAnswer(r.Answer)
+	}
+	if r.Review != nil {
+		_ = s.memory.SaveReview(r.Review)
+	}
+	if r.DeletedReview != nil {
+		_ = s.memory.DeleteReview(r.DeletedReview.UserID, r.DeletedReview.TaskID)
+	}
+	if r.TaskFailure != nil {
+		_ = s.memory.SaveTaskFailure(r.TaskFailure)
+	}
+	if r.DeletedTaskFailure != nil {
+		_ = s.memory.DeleteTaskFailure(r.DeletedTaskFailure.TaskID)
+	}
+}
+
+func (s *FileStore) replay() error {
+	file, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 {
+			var r record
+			if jsonErr := json.Unmarshal(data, &r); jsonErr != nil {
+				if err == io.EOF {
+					// The last record was cut off by a crash in the middle of a write.
+					return nil
+				}
+				return fmt.Errorf("state file %s line %d: %v", s.path, line, jsonErr)
+			}
+			s.apply(&r)
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *FileStore) compact() error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	writer := bufio.NewWriter(tmpFile)
+	err = s.writeSnapshot(json.NewEncoder(writer))
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), s.path)
+}
+
+func (s *FileStore) writeSnapshot(encoder *json.Encoder) error {
+	s.memory.mutex.RLock()
+	defer s.memory.mutex.RUnlock()
+	for userID := range s.memory.users {
+		user := s.memory.users[userID]
+		if err := encoder.Encode(&record{User: &user}); err != nil {
+			return err
+		}
+	}
+	expiredAt := time.Now().Add(-pollTTL)
+	for pollID := range s.memory.polls {
+		poll := s.memory.polls[pollID]
+		if poll.SentAt.Before(expiredAt) {
+			continue
+		}
+		if err := encoder.Encode(&record{Poll: &poll}); err != nil {
+			return err
+		}
+	}
+	for _, answers := range s.memory.answers {
+		for i := range answers {
+			if err := encoder.Encode(&record{Answer: &answers[i]}); err != nil {
+				return err
+			}
+		}
+	}
+	for _, reviews := range s.memory.reviews {
+		for taskID := range reviews {
+			review := reviews[taskID]
+			if err := encoder.Encode(&record{Review: &review}); err != nil {
+				return err
+			}
+		}
+	}
+	for taskID := range s.memory.taskFailures {
+		failure := s.memory.taskFailures[taskID]
+		if err := encoder.Encode(&record{TaskFailure: &failure}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
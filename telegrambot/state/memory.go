@@ -0,0 +1,213 @@
+package state
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Polls which were not answered for this long are dropped.
+	pollTTL = 7 * 24 * time.Hour
+	// pollsEvictionPeriod is how often expired polls are looked for.
+	pollsEvictionPeriod = time.Hour
+)
+
+// MemoryStore keeps all state in process memory and loses it on exit.
+type MemoryStore struct {
+	mutex   sync.RWMutex
+	users   map[int]User
+	polls   map[string]Poll
+	answers map[int][]Answer
+	reviews map[int]map[int]Review
+	// taskFailures are kept by task ID.
+	taskFailures map[int]TaskFailure
+
+	pollsEvictedAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:   make(map[int]User),
+		polls:   make(map[string]Poll),
+		answers: make(map[int][]Answer),
+		reviews: make(map[int]map[int]Review),
+
+		taskFailures: make(map[int]TaskFailure),
+	}
+}
+
+func (s *MemoryStore) LoadUser(userID int) (*User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	user, found := s.users[userID]
+	if !found {
+		return &User{ID: userID}, nil
+	}
+	return user.Clone(), nil
+}
+
+func (s *MemoryStore) SaveUser(user *User) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.users[user.ID] = *user.Clone()
+	return nil
+}
+
+func (s *MemoryStore) LoadUsersWithExams() ([]User, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	users := make([]User, 0)
+	for _, user := range s.users {
+		if user.Exam != nil {
+			users = append(users, *user.Clone())
+		}
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+	return users, nil
+}
+
+func (s *MemoryStore) LoadPoll(pollID string) (*Poll, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	poll, found := s.polls[pollID]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &poll, nil
+}
+
+func (s *MemoryStore) SavePoll(poll *Poll) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.polls[poll.ID] = *poll
+	s.evictExpiredPolls(time.Now())
+	return nil
+}
+
+// evictExpiredPolls drops expired polls once per pollsEvictionPeriod, so polls
+// which are never answered don't pile up in a long running process.
+func (s *MemoryStore) evictExpiredPolls(now time.Time) {
+	if now.Sub(s.pollsEvictedAt) < pollsEvictionPeriod {
+		return
+	}
+	s.pollsEvictedAt = now
+	expiredAt := now.Add(-pollTTL)
+	for pollID, poll := range s.polls {
+		if poll.SentAt.Before(expiredAt) {
+			delete(s.polls, pollID)
+		}
+	}
+}
+
+func (s *MemoryStore) DeletePoll(pollID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.polls, pollID)
+	return nil
+}
+
+func (s *MemoryStore) SaveAnswer(answer *Answer) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.answers[answer.UserID] = append(s.answers[answer.UserID], *answer)
+	return nil
+}
+
+func (s *MemoryStore) LoadAnswers(userID int) ([]Answer, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	answers := make([]Answer, len(s.answers[userID]))
+	copy(answers, s.answers[userID])
+	return answers, nil
+}
+
+func (s *MemoryStore) LoadReview(userID, taskID int) (*Review, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	review, found := s.reviews[userID][taskID]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &review, nil
+}
+
+func (s *MemoryStore) LoadReviews(userID int) ([]Review, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	reviews := make([]Review, 0, len(s.reviews[userID]))
+	for _, review := range s.reviews[userID] {
+		reviews = append(reviews, review)
+	}
+	sort.Slice(reviews, func(i, j int) bool {
+		return reviews[i].DueAt.Before(reviews[j].DueAt)
+	})
+	return reviews, nil
+}
+
+func (s *MemoryStore) SaveReview(review *Review) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, found := s.reviews[review.UserID]; !found {
+		s.reviews[review.UserID] = make(map[int]Review)
+	}
+	s.reviews[review.UserID][review.TaskID] = *review
+	return nil
+}
+
+func (s *MemoryStore) DeleteReview(userID, taskID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.reviews[userID], taskID)
+	if len(s.reviews[userID]) == 0 {
+		delete(s.reviews, userID)
+	}
+	return nil
+}
+
+func (s *MemoryStore) LoadTaskFailure(taskID int) (*TaskFailure, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	failure, found := s.taskFailures[taskID]
+	if !found {
+		return nil, ErrNotFound
+	}
+	return &failure, nil
+}
+
+func (s *MemoryStore) LoadTaskFailures() ([]TaskFailure, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	failures := make([]TaskFailure, 0, len(s.taskFailures))
+	for _, failure := range s.taskFailures {
+		failures = append(failures, failure)
+	}
+	sort.Slice(failures, func(i, j int) bool {
+		return failures[i].TaskID < failures[j].TaskID
+	})
+	return failures, nil
+}
+
+func (s *MemoryStore) SaveTaskFailure(failure *TaskFailure) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.taskFailures[failure.TaskID] = *failure
+	return nil
+}
+
+func (s *MemoryStore) DeleteTaskFailure(taskID int) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.taskFailures, taskID)
+	return nil
+}
+
+func (s *MemoryStore) Ping() error {
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
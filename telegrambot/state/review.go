@@ -0,0 +1,38 @@
+package state
+
+import (
+	"time"
+)
+
+// Leitner boxes: a wrong answer puts the task into the first box, every correct
+// review moves it to the next box with a longer interval until it is learned.
+var reviewIntervals = []time.Duration{
+	10 * time.Minute,
+	24 * time.Hour,
+	3 * 24 * time.Hour,
+	7 * 24 * time.Hour,
+	16 * 24 * time.Hour,
+}
+
+// ScheduleReview returns the next review of the task after the answer or nil if
+// the task does not need to be reviewed anymore. Review may be nil if the task
+// was not scheduled before.
+func ScheduleReview(review *Review, userID, taskID int, correct bool, now time.Time) *Review {
+	if !correct {
+		return &Review{
+			UserID: userID,
+			TaskID: taskID,
+			Box:    0,
+			DueAt:  now.Add(reviewIntervals[0]),
+		}
+	}
+	if review == nil || review.Box+1 >= len(reviewIntervals) {
+		return nil
+	}
+	return &Review{
+		UserID: userID,
+		TaskID: taskID,
+		Box:    review.Box + 1,
+		DueAt:  now.Add(reviewIntervals[review.Box+1]),
+	}
+}
@@ -0,0 +1,64 @@
+package state
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleReview(t *testing.T) {
+	now := time.Date(2021, 9, 1, 12, 0, 0, 0, time.UTC)
+	lastBox := len(reviewIntervals) - 1
+	testCases := []struct {
+		name     string
+		review   *Review
+		correct  bool
+		expected *Review
+	}{
+		{
+			name:     "first wrong answer",
+			correct:  false,
+			expected: &Review{UserID: 1, TaskID: 2, Box: 0, DueAt: now.Add(reviewIntervals[0])},
+		},
+		{
+			name:     "wrong answer resets box",
+			review:   &Review{UserID: 1, TaskID: 2, Box: 3},
+			correct:  false,
+			expected: &Review{UserID: 1, TaskID: 2, Box: 0, DueAt: now.Add(reviewIntervals[0])},
+		},
+		{
+			name:    "correct answer of not scheduled task",
+			correct: true,
+		},
+		{
+			name:     "correct answer moves to next box",
+			review:   &Review{UserID: 1, TaskID: 2, Box: 0},
+			correct:  true,
+			expected: &Review{UserID: 1, TaskID: 2, Box: 1, DueAt: now.Add(reviewIntervals[1])},
+		},
+		{
+			name:     "correct answer moves to last box",
+			review:   &Review{UserID: 1, TaskID: 2, Box: lastBox - 1},
+			correct:  true,
+			expected: &Review{UserID: 1, TaskID: 2, Box: lastBox, DueAt: now.Add(reviewIntervals[lastBox])},
+		},
+		{
+			name:    "correct answer in last box learns task",
+			review:  &Review{UserID: 1, TaskID: 2, Box: lastBox},
+			correct: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			review := ScheduleReview(testCase.review, 1, 2, testCase.correct, now)
+			if testCase.expected == nil {
+				if review != nil {
+					t.Errorf("expected no review, got %+v", *review)
+				}
+				return
+			}
+			if review == nil || *review != *testCase.expected {
+				t.Errorf("expected %+v, got %+v", *testCase.expected, review)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package state
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Sessions serializes read-modify-write access to the users of a Store, so
+// concurrent updates of the same user never overwrite each other.
+type Sessions struct {
+	store Store
+
+	mutex sync.Mutex
+	locks map[int]*userLock
+}
+
+type userLock struct {
+	sync.Mutex
+	references int
+}
+
+func NewSessions(store Store) *Sessions {
+	return &Sessions{
+		store: store,
+		locks: make(map[int]*userLock),
+	}
+}
+
+// Load returns a snapshot of the user which is not affected by later updates.
+func (s *Sessions) Load(userID int) (*User, error) {
+	lock := s.acquire(userID)
+	defer s.release(userID, lock)
+	return s.store.LoadUser(userID)
+}
+
+// Update loads the user, applies the update and saves the user if anything changed.
+// No other update of the same user can run in between.
+func (s *Sessions) Update(userID int, update func(user *User)) error {
+	lock := s.acquire(userID)
+	defer s.release(userID, lock)
+
+	user, err := s.store.LoadUser(userID)
+	if err != nil {
+		return err
+	}
+	before := user.Clone()
+	update(user)
+	if reflect.DeepEqual(user, before) {
+		return nil
+	}
+	return s.store.SaveUser(user)
+}
+
+func (s *Sessions) acquire(userID int) *userLock {
+	s.mutex.Lock()
+	lock, found := s.locks[userID]
+	if !found {
+		lock = &userLock{}
+		s.locks[userID] = lock
+	}
+	lock.references++
+	s.mutex.Unlock()
+
+	lock.Lock()
+	return lock
+}
+
+func (s *Sessions) release(userID int, lock *userLock) {
+	lock.Unlock()
+
+	s.mutex.Lock()
+	lock.references--
+	if lock.references == 0 {
+		delete(s.locks, userID)
+	}
+	s.mutex.Unlock()
+}
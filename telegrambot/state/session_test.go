@@ -0,0 +1,208 @@
+package state
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	concurrentUsers   = 20
+	concurrentUpdates = 200
+)
+
+func TestSessionsConcurrentUpdates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileStore, err := NewFileStore(filepath.Join(dir, "users.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fileStore.Close()
+
+	for name, store := range map[string]Store{"memory": NewMemoryStore(), "file": fileStore} {
+		t.Run(name, func(t *testing.T) {
+			sessions := NewSessions(store)
+
+			var wg sync.WaitGroup
+			for userID := 1; userID <= concurrentUsers; userID++ {
+				for i := 0; i < concurrentUpdates; i++ {
+					wg.Add(1)
+					go func(userID int) {
+						defer wg.Done()
+						err := sessions.Update(userID, func(user *User) {
+							user.ChatID++
+							user.SelectedSubject = "subject"
+						})
+						if err != nil {
+							t.Error(err)
+						}
+						if _, err := sessions.Load(userID); err != nil {
+							t.Error(err)
+						}
+					}(userID)
+				}
+			}
+			wg.Wait()
+
+			for userID := 1; userID <= concurrentUsers; userID++ {
+				user, err := sessions.Load(userID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if user.ChatID != concurrentUpdates {
+					t.Errorf("user %d: expected %d updates, got %d", userID, concurrentUpdates, user.ChatID)
+				}
+			}
+			if len(sessions.locks) != 0 {
+				t.Errorf("expected all user locks to be released, got %d", len(sessions.locks))
+			}
+		})
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.jsonl")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := NewSessions(store)
+	for _, subject := range []string{"first", "second"} {
+		subject := subject
+		if err := sessions.Update(1, func(user *User) { user.SelectedSubject = subject }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	user, err := store.LoadUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.SelectedSubject != "second" {
+		t.Errorf("expected last saved subject, got %q", user.SelectedSubject)
+	}
+}
+
+func TestFileStoreTaskFailures(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.jsonl")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, failure := range []TaskFailure{{TaskID: 2, Count: 3, Quarantined: true}, {TaskID: 1, Count: 1}, {TaskID: 3, Count: 1}} {
+		failure := failure
+		if err := store.SaveTaskFailure(&failure); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.DeleteTaskFailure(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	failures, err := store.LoadTaskFailures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 || failures[0].TaskID != 1 || failures[1].TaskID != 2 || !failures[1].Quarantined {
+		t.Errorf("unexpected task failures after reopen %+v", failures)
+	}
+	if _, err := store.LoadTaskFailure(3); err != ErrNotFound {
+		t.Errorf("expected deleted task failure to be not found, got %v", err)
+	}
+}
+
+func TestFileStoreCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "users.jsonl")
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	level := strings.Repeat("x", minCompactionSize/10)
+	for i := 0; i < 30; i++ {
+		if err := store.SaveUser(&User{ID: 1, SelectedLevel: level, SelectedThemeCode: strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() > minCompactionSize+2*int64(len(level)) {
+		t.Fatalf("expected journal to be compacted while running, got %v, %v", info.Size(), err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if user, _ := store.LoadUser(1); user.SelectedThemeCode != "29" {
+		t.Errorf("expected last saved user after compaction, got %q", user.SelectedThemeCode)
+	}
+}
+
+func TestMemoryStoreEvictsExpiredPolls(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Now()
+	for _, poll := range []Poll{{ID: "expired", SentAt: now.Add(-pollTTL - time.Hour)}, {ID: "fresh", SentAt: now}} {
+		poll := poll
+		if err := store.SavePoll(&poll); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.mutex.Lock()
+	store.evictExpiredPolls(now.Add(pollsEvictionPeriod))
+	store.mutex.Unlock()
+	if _, err := store.LoadPoll("expired"); err != ErrNotFound {
+		t.Errorf("expected expired poll to be evicted, got %v", err)
+	}
+	if _, err := store.LoadPoll("fresh"); err != nil {
+		t.Errorf("expected fresh poll to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package state
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// User is everything the bot remembers about a single Telegram user.
+type User struct {
+	ID                int    `json:"id"`
+	ChatID            int64  `json:"chatId"`
+	SelectedSubjectID string `json:"selectedSubjectId"`
+	// SelectedSubject is the name of the subject selected before subjects were
+	// stored by ID, it is replaced by SelectedSubjectID on the next selection.
+	SelectedSubject   string `json:"selectedSubject,omitempty"`
+	SelectedLevel     string `json:"selectedLevel"`
+	SelectedThemeCode string `json:"selectedThemeCode"`
+	Exam              *Exam  `json:"exam,omitempty"`
+}
+
+// IsNew reports whether the user has never talked to the bot before.
+func (u *User) IsNew() bool {
+	return u.ChatID == 0
+}
+
+// Clone returns a deep copy of the user.
+func (u *User) Clone() *User {
+	clone := *u
+	if u.Exam != nil {
+		clone.Exam = u.Exam.Clone()
+	}
+	return &clone
+}
+
+// Exam is a mock exam in progress. Results are aligned with TaskIDs.
+type Exam struct {
+	SubjectName string       `json:"subjectName"`
+	TaskIDs     []int        `json:"taskIds"`
+	Results     []ExamResult `json:"results"`
+	StartedAt   time.Time    `json:"startedAt"`
+	Deadline    time.Time    `json:"deadline"`
+}
+
+type ExamResult struct {
+	Answered bool `json:"answered"`
+	Correct  bool `json:"correct"`
+	// Skipped is set when the task was rejected by Telegram, it is not scored.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+func (e *Exam) Clone() *Exam {
+	clone := *e
+	clone.TaskIDs = append([]int(nil), e.TaskIDs...)
+	clone.Results = append([]ExamResult(nil), e.Results...)
+	return &clone
+}
+
+func (e *Exam) IsExpired(now time.Time) bool {
+	return !now.Before(e.Deadline)
+}
+
+// NextTaskIndex returns the index of the first unanswered task or -1 if all tasks
+// are answered or skipped.
+func (e *Exam) NextTaskIndex() int {
+	for i, result := range e.Results {
+		if !result.Answered && !result.Skipped {
+			return i
+		}
+	}
+	return -1
+}
+
+// Poll links a quiz poll sent to a user with the task it was made from.
+type Poll struct {
+	ID              string    `json:"id"`
+	TaskID          int       `json:"taskId"`
+	CorrectOptionID int       `json:"correctOptionId"`
+	UserID          int       `json:"userId"`
+	ChatID          int64     `json:"chatId"`
+	SentAt          time.Time `json:"sentAt"`
+}
+
+// Answer is a single attempt of a user to solve a task.
+type Answer struct {
+	UserID     int       `json:"userId"`
+	TaskID     int       `json:"taskId"`
+	Correct    bool      `json:"correct"`
+	AnsweredAt time.Time `json:"answeredAt"`
+}
+
+// Review schedules a task which the user answered incorrectly to be solved again.
+type Review struct {
+	UserID int       `json:"userId"`
+	TaskID int       `json:"taskId"`
+	Box    int       `json:"box"`
+	DueAt  time.Time `json:"dueAt"`
+}
+
+// IsDue reports whether it is time to review the task.
+func (r *Review) IsDue(now time.Time) bool {
+	return !r.DueAt.After(now)
+}
+
+// TaskFailure counts attempts to send a task which were rejected by Telegram.
+// A quarantined task is not sent to users until an admin clears it.
+type TaskFailure struct {
+	TaskID       int       `json:"taskId"`
+	Count        int       `json:"count"`
+	LastError    string    `json:"lastError"`
+	LastFailedAt time.Time `json:"lastFailedAt"`
+	Quarantined  bool      `json:"quarantined"`
+}
+
+// Store keeps per-user state between restarts of the bot.
+type Store interface {
+	// LoadUser returns a copy of the stored user or an empty user with the given ID.
+	LoadUser(userID int) (*User, error)
+	// SaveUser stores a copy of the user.
+	SaveUser(user *User) error
+	// LoadUsersWithExams returns copies of users with an exam in progress ordered by ID.
+	LoadUsersWithExams() ([]User, error)
+
+	// LoadPoll returns ErrNotFound for unknown or already answered polls.
+	LoadPoll(pollID string) (*Poll, error)
+	SavePoll(poll *Poll) error
+	DeletePoll(pollID string) error
+
+	SaveAnswer(answer *Answer) error
+	// LoadAnswers returns all answers of the user in chronological order.
+	LoadAnswers(userID int) ([]Answer, error)
+
+	// LoadReview returns ErrNotFound if the task is not scheduled for review.
+	LoadReview(userID, taskID int) (*Review, error)
+	// LoadReviews returns all reviews of the user ordered by due time.
+	LoadReviews(userID int) ([]Review, error)
+	SaveReview(review *Review) error
+	DeleteReview(userID, taskID int) error
+
+	// LoadTaskFailure returns ErrNotFound if sending the task has not failed.
+	LoadTaskFailure(taskID int) (*TaskFailure, error)
+	// LoadTaskFailures returns failures of all tasks ordered by task ID.
+	LoadTaskFailures() ([]TaskFailure, error)
+	SaveTaskFailure(failure *TaskFailure) error
+	DeleteTaskFailure(taskID int) error
+
+	// Ping checks that the store can still save state.
+	Ping() error
+	Close() error
+}
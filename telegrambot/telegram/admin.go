@@ -0,0 +1,16 @@
+package telegram
+
+// UseAdmins allows the users to run admin commands in any chat with the bot.
+// Must be called before Run. By default there are no admins and admin commands
+// are ignored.
+func (b *Bot) UseAdmins(userIDs []int) {
+	b.admins = make(map[int]struct{}, len(userIDs))
+	for _, userID := range userIDs {
+		b.admins[userID] = struct{}{}
+	}
+}
+
+func (b *Bot) isAdmin(userID int) bool {
+	_, found := b.admins[userID]
+	return found
+}
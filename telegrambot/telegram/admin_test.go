@@ -0,0 +1,66 @@
+package telegram
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReloadKeepsOldDatabaseOnError(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	tasksPath := filepath.Join(b.dir, "physics.json")
+	database := b.database()
+
+	if err := ioutil.WriteFile(tasksPath, []byte(`{"tasks": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.sendAdminCommand("/" + commandReload)
+	if b.database() != database || b.loader.Status().LastError == nil {
+		t.Fatalf("expected failed reload to keep the old database, got error %v", b.loader.Status().LastError)
+	}
+	if _, found := b.database().GetTask(1); !found {
+		t.Error("expected tasks of the old database to be available")
+	}
+
+	if err := ioutil.WriteFile(tasksPath, []byte(testTasks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.sendCommandFrom(testUserID, "/"+commandReload)
+	if b.database() != database {
+		t.Fatal("expected reload by a user who is not an admin to be ignored")
+	}
+	b.sendAdminCommand("/" + commandReload)
+	if b.database() == database || b.loader.Status().LastError != nil {
+		t.Errorf("expected database to be reloaded, got error %v", b.loader.Status().LastError)
+	}
+}
+
+func TestReloadSkipsBrokenTasks(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	tasks := `{"tasks": [
+		{"id": 1, "level": 1, "text": "Сколько будет 2 + 2?", "answer": "2", "options": {"1": "3", "2": "4", "3": "5"}},
+		{"id": 3, "level": 1, "text": "Сколько будет 3 + 3?", "answer": "7", "options": {"1": "5", "2": "6"}}
+	]}`
+	if err := ioutil.WriteFile(filepath.Join(b.dir, "physics.json"), []byte(tasks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.client.takeSent()
+
+	b.reloadDatabase()
+	if b.loader.Status().LastError != nil {
+		t.Fatalf("expected database to be reloaded, got error %v", b.loader.Status().LastError)
+	}
+	if _, found := b.database().GetTask(1); !found {
+		t.Error("expected valid task to be reloaded")
+	}
+	if _, found := b.database().GetTask(3); found {
+		t.Error("expected broken task to be skipped")
+	}
+	alerts := b.client.takeAlerts()
+	if len(alerts) != 1 || !strings.Contains(alerts[0], "task 3: answer") {
+		t.Errorf("expected an alert about the skipped task, got %q", alerts)
+	}
+}
@@ -0,0 +1,70 @@
+package telegram
+
+import (
+	"fmt"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/alert"
+	"github.com/ravil23/usebot/telegrambot/botapi"
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+// UseAlerter makes the bot send alerts to the sink, throttled by the config.
+// Must be called before Run. By default alerts are sent to AlertsChatID.
+func (b *Bot) UseAlerter(sink alert.Alerter, config alert.ThrottleConfig) {
+	b.alerts = alert.NewThrottler(alert.WithPrefix(fmt.Sprintf("[%s] ", b.hostName), sink), config)
+}
+
+// TelegramAlerter sends alerts to AlertsChatID by the bot itself.
+func (b *Bot) TelegramAlerter() alert.Alerter {
+	return telegramAlerter{bot: b, chatID: AlertsChatID}
+}
+
+type telegramAlerter struct {
+	bot    *Bot
+	chatID int64
+}
+
+func (a telegramAlerter) Alert(alert alert.Alert) {
+	text := truncateText(alert.Text, botapi.MaxMessageLength)
+	if _, err := a.bot.client(priorityAlert).Send(tgbotapi.NewMessage(a.chatID, text)); err != nil {
+		logging.Error("Error on sending alert", logging.Err(err))
+	}
+}
+
+// sendAlert logs the message and sends it to the alerter. Fields are redacted
+// in both places by the logging policy. Alerts with the same message and error
+// are grouped by the throttler. Alerts without an error are informational, like
+// a started conversation, so they are keyed by their whole text and never merge
+// with alerts about other users.
+func (b *Bot) sendAlert(message string, fields ...logging.Field) {
+	b.log().Warn(message, fields...)
+	text := b.log().Format(message, fields...)
+	key := text
+	for _, field := range fields {
+		if field.Key == logging.ErrorKey && field.Value != nil {
+			key = fmt.Sprintf("%s: %v", message, field.Value)
+		}
+	}
+	b.alerts.Alert(alert.Alert{Key: key, Text: text})
+}
+
+// forUpdate returns a copy of the bot which adds the update ID to its logs and
+// alerts. The copy shares all state with the bot.
+func (b *Bot) forUpdate(updateID int) *Bot {
+	return b.withLogger(b.log().With(logging.Int("updateId", updateID)))
+}
+
+func (b *Bot) withLogger(logger *logging.Logger) *Bot {
+	bot := *b
+	bot.logger = logger
+	return &bot
+}
+
+func (b *Bot) log() *logging.Logger {
+	if b.logger == nil {
+		return logging.Default()
+	}
+	return b.logger
+}
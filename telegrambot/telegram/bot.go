@@ -1,8 +1,8 @@
 package telegram
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"math/rand"
 	"net/http"
 	"os"
@@ -13,7 +13,11 @@ import (
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
+	"github.com/ravil23/usebot/telegrambot/alert"
 	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/logging"
+	"github.com/ravil23/usebot/telegrambot/render"
+	"github.com/ravil23/usebot/telegrambot/state"
 )
 
 const (
@@ -21,136 +25,285 @@ const (
 	initializationMaxRetriesCount = 30
 	timeoutSeconds                = 60
 	listenersPoolSize             = 10
+	listenerQueueSize             = 100
+	updatesRetryPeriod            = 3 * time.Second
+	// shutdownTimeout fits into 10 seconds which docker gives to stop a container.
+	shutdownTimeout = 8 * time.Second
 )
 
+// Bot is shallow copied for every update to log with its ID, see forUpdate. Fields
+// changed while the bot is running must be behind pointers to be shared.
 type Bot struct {
-	hostName string
-	api      *tgbotapi.BotAPI
-	database *collection.Database
+	hostName  string
+	api       telegramClient
+	loader    *collection.Loader
+	store     state.Store
+	sessions  *state.Sessions
+	callbacks *callbackSigner
+	metrics   *botMetrics
+	alerts    *alert.Throttler
+	admins    map[int]struct{}
+	// logger adds fields of the handled update, the default logger is used if nil.
+	logger *logging.Logger
+
+	quarantine *quarantine
+	scheduler  *sendScheduler
+	examTimers *examTimers
+
+	server        *http.Server
+	dispatcher    *dispatcher
+	receivingDone chan struct{}
+	receiving     *receivingState
+
+	webhook        *WebhookConfig
+	webhookUpdates chan tgbotapi.Update
+	webhookClosed  chan struct{}
 }
 
-func NewBot(database *collection.Database) *Bot {
+func NewBot(loader *collection.Loader, store state.Store) *Bot {
 	hostName, err := os.Hostname()
 	if err != nil {
 		hostName = "unknown_host"
 	}
-	return &Bot{
-		hostName: hostName,
-		database: database,
+	b := &Bot{
+		hostName:   hostName,
+		loader:     loader,
+		store:      store,
+		sessions:   state.NewSessions(store),
+		metrics:    newBotMetrics(),
+		quarantine: newQuarantine(store),
+		scheduler:  newSendScheduler(defaultSendLimits),
+		examTimers: newExamTimers(),
+		dispatcher: newDispatcher(listenersPoolSize, listenerQueueSize),
+		receiving:  &receivingState{},
 	}
+	b.UseAlerter(b.TelegramAlerter(), alert.DefaultThrottleConfig)
+	return b
 }
 
-func (b *Bot) Init() {
-	log.Printf("Bot is initializing...")
+// Init connects to Bot API. Empty endpoint means the official one, otherwise it
+// is a base URL like http://localhost:8081 of a local or fake Bot API server.
+func (b *Bot) Init(apiEndpoint string) {
+	logging.Info("Bot is initializing")
 	botToken := getBotTokenOrPanic()
+	b.callbacks = newCallbackSigner(getCallbackSecret(botToken))
+	httpClient, err := newHTTPClient(apiEndpoint)
+	if err != nil {
+		logging.Panic("Invalid Bot API endpoint", logging.Err(err))
+	}
 	rand.Seed(time.Now().UnixNano())
 	for i := 1; i <= initializationMaxRetriesCount; i++ {
-		if api, err := tgbotapi.NewBotAPI(botToken); err != nil {
-			log.Printf("Attempt %d failed: %v", i, err)
+		if api, err := tgbotapi.NewBotAPIWithClient(botToken, httpClient); err != nil {
+			logging.Warn("Initialization attempt failed", logging.Int("attempt", i), logging.Err(err))
 			time.Sleep(initializationRetryPeriod)
 		} else {
-			b.api = api
+			b.api = scheduledClient{
+				telegramClient: meteredClient{telegramClient: api, metrics: b.metrics},
+				scheduler:      b.scheduler,
+				priority:       priorityReply,
+			}
 			return
 		}
 	}
-	log.Panic("max retries count exceeded")
+	logging.Panic("Max initialization retries count exceeded")
 }
 
 func (b *Bot) HealthCheck() {
+	address := ":8080"
+	path := "/healthcheck"
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		logging.Info(
+			"Health check request",
+			logging.String("method", r.Method),
+			logging.String("url", r.Host+r.URL.String()),
+			logging.String("userAgent", r.UserAgent()),
+			logging.String("requestId", r.Header.Get(requestIDHeader)),
+		)
+		_, _ = fmt.Fprint(w, `{"status": "ok"}`)
+	})
+	mux.HandleFunc("/livez", b.handleLiveness)
+	mux.HandleFunc("/readyz", b.handleReadiness)
+	mux.Handle("/metrics", b.metrics.registry)
+	if b.webhook != nil {
+		webhookPath, _ := b.webhook.path()
+		mux.HandleFunc(webhookPath, b.handleWebhook)
+		logging.Info("Listening webhook", logging.String("address", address+webhookPath))
+	}
+	b.server = &http.Server{Addr: address, Handler: mux}
 	go func() {
-		address := ":8080"
-		path := "/healthcheck"
-		http.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-			log.Printf("%s request to %s%s with User-Agent: %s", r.Method, r.Host, r.URL, r.UserAgent())
-			_, _ = fmt.Fprint(w, `{"status": "ok"}`)
-		})
-		log.Printf("Listening health check on address %s%s", address, path)
-		if err := http.ListenAndServe(address, nil); err != nil {
-			log.Panic(err)
+		logging.Info("Listening health check", logging.String("address", address+path))
+		var err error
+		if b.webhook != nil && b.webhook.CertPath != "" {
+			err = b.server.ListenAndServeTLS(b.webhook.CertPath, b.webhook.KeyPath)
+		} else {
+			err = b.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			logging.Panic("Error on serving HTTP", logging.Err(err))
 		}
 	}()
 }
 
-func (b *Bot) Run() {
-	log.Printf("Bot is running...")
-	updateConfig := tgbotapi.NewUpdate(0)
-	updateConfig.Timeout = timeoutSeconds
-	updates := b.api.GetUpdatesChan(updateConfig)
-
-	listener := func() {
-		for update := range updates {
-			if update.Message != nil {
-				b.handleMessage(update.Message)
-			} else if update.CallbackQuery != nil {
-				b.handleCallbackQuery(update.CallbackQuery)
-			} else if update.PollAnswer != nil {
-				b.handlePollAnswer(update.PollAnswer)
-			} else {
-				continue
-			}
+// Run handles updates until SIGINT or SIGTERM. It returns false if some handlers
+// or exam timers were still running when the bot stopped, then they may write
+// to the store later.
+func (b *Bot) Run() bool {
+	logging.Info("Bot is running")
+	ctx, stopReceiving := context.WithCancel(context.Background())
+	b.alerts.Start()
+	defer b.alerts.Close()
+	b.restoreExamTimers()
+	b.listen(ctx)
+	b.serve()
+	return b.shutdown(stopReceiving)
+}
+
+func (b *Bot) serve() {
+	b.sendAlert(fmt.Sprintf("@%s started", Bot11Name))
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			b.reloadDatabase()
+			continue
 		}
+		break
 	}
+}
 
-	for i := 0; i < listenersPoolSize; i++ {
-		go listener()
+// shutdown stops receiving updates, waits for updates already received to be
+// handled, stops exam timers and the HTTP server. Everything must be done within
+// shutdownTimeout, otherwise the bot is killed by the platform anyway. Returns
+// false if handlers or exam timers are still running.
+func (b *Bot) shutdown(stopReceiving context.CancelFunc) bool {
+	logging.Info("Bot is stopping")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if b.webhook != nil {
+		b.deleteWebhook()
+	}
+	stopReceiving()
+	<-b.receivingDone
+	if b.server != nil {
+		if err := b.server.Shutdown(ctx); err != nil {
+			logging.Warn("Error on stopping HTTP server", logging.Err(err))
+		}
+	}
+	if b.webhook != nil {
+		b.dispatchBufferedWebhookUpdates()
 	}
 
-	b.serve()
+	if !b.dispatcher.stop(ctx) {
+		b.sendAlert(fmt.Sprintf("@%s stopped before all updates were handled", Bot11Name))
+		return false
+	}
+	if b.webhook == nil {
+		b.acknowledgeUpdates()
+	}
+	// Exams not finished by now are finished by restored timers after restart.
+	if !b.examTimers.stop(ctx) {
+		b.sendAlert(fmt.Sprintf("@%s stopped before all expired exams were finished", Bot11Name))
+		return false
+	}
+	b.sendAlert(fmt.Sprintf("@%s stopped", Bot11Name))
+	return true
 }
 
-func (b *Bot) TestAllTasks(chatID int64) {
-	log.Printf("Bot is sending all tasks to chat %d", chatID)
+// database returns the current task database. A reload may replace it between
+// two calls, so a task or subject found by one call may be missing in the next.
+func (b *Bot) database() *collection.Database {
+	return b.loader.Database()
+}
 
-	for name, subject := range b.database.Subjects {
-		log.Printf("%s: %s", name, subject)
-		for _, task := range subject.Tasks {
-			var tgChattable tgbotapi.Chattable
-			if task.SendAsPoll {
-				tgChattable = task.MakeTelegramPoll(chatID)
-			} else {
-				tgChattable = task.MakeTelegramMessage(chatID)
-			}
-			if _, err := b.api.Send(tgChattable); err != nil {
-				b.sendAlert(fmt.Sprintf("Error on sending %v: %s", tgChattable, err))
-				panic(err)
-			}
-		}
+func (b *Bot) reloadDatabase() {
+	database, err := b.loader.Reload()
+	if err != nil {
+		b.sendAlert("Error on reloading database, old data is kept", logging.Err(err))
+		return
+	}
+	fields := []logging.Field{logging.Int("subjects", len(database.SubjectIDs)), logging.Int("tasks", len(database.Tasks))}
+	if len(database.SkippedTasks) == 0 {
+		b.sendAlert("Database reloaded", fields...)
+		return
 	}
+	skippedTasks := make([]string, 0, len(database.SkippedTasks))
+	for _, problem := range database.SkippedTasks {
+		skippedTasks = append(skippedTasks, problem.String())
+	}
+	fields = append(fields, logging.String("skippedTasks", strings.Join(skippedTasks, "; ")))
+	b.sendAlert("Database reloaded, broken tasks are skipped", fields...)
 }
 
-func (b *Bot) serve() {
-	b.sendAlert(fmt.Sprintf("@%s started", Bot11Name))
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
-	<-signals
-	b.sendAlert(fmt.Sprintf("@%s stopped", Bot11Name))
+func (b *Bot) handleUpdate(update tgbotapi.Update) {
+	startedAt := time.Now()
+	b = b.forUpdate(update.UpdateID)
+	fields := []logging.Field{logging.String("type", getUpdateType(update))}
+	if userID, ok := getUpdateUserID(update); ok {
+		b.metrics.activeUsers.mark(userID, startedAt)
+		fields = append(fields, logging.UserID(userID))
+	}
+	b.log().Debug("Update received", fields...)
+	defer func() {
+		duration := time.Since(startedAt)
+		b.metrics.observeUpdate(update, duration)
+		b.log().Debug("Update handled", append(fields, logging.Duration("duration", duration))...)
+	}()
+
+	if update.Message != nil {
+		b.handleMessage(update.Message)
+	} else if update.CallbackQuery != nil {
+		b.handleCallbackQuery(update.CallbackQuery)
+	} else if update.PollAnswer != nil {
+		b.handlePollAnswer(update.PollAnswer)
+	}
 }
 
 func (b *Bot) handleMessage(tgMessage *tgbotapi.Message) {
 	chatID := tgMessage.Chat.ID
-	userID := tgMessage.From.ID
 
-	if _, found := userChat[userID]; !found {
+	isNewUser := false
+	b.updateUser(tgMessage.From.ID, func(user *state.User) {
+		isNewUser = user.IsNew()
+		user.ChatID = chatID
+	})
+	if isNewUser || tgMessage.Command() == commandStart {
 		b.sendWithAlertOnError(b.getStartMenu(chatID, tgMessage.From))
 	}
-	userChat[userID] = chatID
 
 	if tgMessage.Command() == commandStart {
 		b.sendWithAlertOnError(b.getSubjectsList(chatID))
-		b.sendAlert(fmt.Sprintf("%s started conversation with @%s", formatUserStringVerbose(tgMessage.From), Bot11Name))
+		b.sendAlert(fmt.Sprintf("User started conversation with @%s", Bot11Name), userFields(tgMessage.From)...)
 	} else if tgMessage.Text == commandSelectSubject {
 		b.sendWithAlertOnError(b.getSubjectsList(chatID))
 	} else if tgMessage.Text == commandSelectLevel {
 		b.sendWithAlertOnError(b.getLevelsList(chatID))
+	} else if tgMessage.Text == commandSelectTheme {
+		b.sendWithAlertOnError(b.getThemesList(chatID, tgMessage.From.ID))
 	} else if tgMessage.Text == commandNext {
 		b.sendNextTask(chatID, tgMessage.From.ID)
+	} else if tgMessage.Text == commandReview {
+		b.sendReviewTask(chatID, tgMessage.From.ID)
+	} else if tgMessage.Text == commandExam {
+		b.startExam(chatID, tgMessage.From.ID)
+	} else if tgMessage.Command() == commandStats {
+		b.sendWithAlertOnError(b.getStats(chatID, tgMessage.From.ID))
+	} else if tgMessage.Command() == commandReload && b.isAdmin(tgMessage.From.ID) {
+		b.reloadDatabase()
+	} else if tgMessage.Command() == commandQuarantine && b.isAdmin(tgMessage.From.ID) {
+		b.sendWithAlertOnError(b.getQuarantineList(chatID))
+	} else if tgMessage.Command() == commandUnquarantine && b.isAdmin(tgMessage.From.ID) {
+		b.unquarantine(chatID, strings.TrimSpace(tgMessage.CommandArguments()))
 	}
 }
 
 func (b *Bot) handleCallbackQuery(tgCallbackQuery *tgbotapi.CallbackQuery) {
 	chatID := tgCallbackQuery.Message.Chat.ID
 
-	userChat[tgCallbackQuery.From.ID] = chatID
+	b.updateUser(tgCallbackQuery.From.ID, func(user *state.User) {
+		user.ChatID = chatID
+	})
 
 	if tgCallbackQuery.Message.Text == textSelectSubject {
 		if b.selectSubject(tgCallbackQuery) {
@@ -160,25 +313,76 @@ func (b *Bot) handleCallbackQuery(tgCallbackQuery *tgbotapi.CallbackQuery) {
 		if b.selectLevel(tgCallbackQuery) {
 			b.sendNextTask(chatID, tgCallbackQuery.From.ID)
 		}
+	} else if isExamCallbackData(tgCallbackQuery.Data) {
+		b.answerExamTask(tgCallbackQuery)
+	} else if strings.HasPrefix(tgCallbackQuery.Message.Text, textSelectTheme) {
+		if b.selectTheme(tgCallbackQuery) {
+			b.sendNextTask(chatID, tgCallbackQuery.From.ID)
+		}
 	} else {
 		b.updateInlineQuestion(tgCallbackQuery)
 	}
 }
 
-func (b *Bot) handlePollAnswer(_ *tgbotapi.PollAnswer) {
+func (b *Bot) handlePollAnswer(tgPollAnswer *tgbotapi.PollAnswer) {
+	userID := tgPollAnswer.User.ID
+	if len(tgPollAnswer.OptionIDs) == 0 {
+		return
+	}
+
+	poll, err := b.store.LoadPoll(tgPollAnswer.PollID)
+	if err == state.ErrNotFound {
+		b.log().Info("Answer to unknown poll", logging.UserID(userID), logging.String("pollId", tgPollAnswer.PollID))
+		return
+	} else if err != nil {
+		b.sendAlert("Error on loading poll", logging.String("pollId", tgPollAnswer.PollID), logging.Err(err))
+		return
+	}
+
+	correct := len(tgPollAnswer.OptionIDs) == 1 && tgPollAnswer.OptionIDs[0] == poll.CorrectOptionID
+	b.saveAnswer(userID, poll.TaskID, correct)
+	if err := b.store.DeletePoll(poll.ID); err != nil {
+		b.sendAlert("Error on deleting poll", logging.String("pollId", poll.ID), logging.Err(err))
+	}
+
+	b.sendNextTask(poll.ChatID, userID)
 }
 
+// selectSubject selects the subject by its ID in callback data. Keyboards sent
+// before subjects were selected by ID have names in callback data.
 func (b *Bot) selectSubject(callbackQuery *tgbotapi.CallbackQuery) bool {
-	userSelectedSubject[callbackQuery.From.ID] = callbackQuery.Data
+	var popupIfSucceeded string
+	if callbackQuery.Data != labelAnswered {
+		database := b.database()
+		subject, found := database.GetSubjectByID(callbackQuery.Data)
+		if !found {
+			subject, found = database.GetSubjectByName(callbackQuery.Data)
+		}
+		if !found {
+			b.sendCallback(callbackQuery.ID, fmt.Sprintf(`Предмет не найден, воспользуйтесь кнопкой "%s"`, commandSelectSubject))
+			return false
+		}
+		b.updateUser(callbackQuery.From.ID, func(user *state.User) {
+			if user.SelectedSubjectID != subject.ID {
+				user.SelectedThemeCode = ""
+			}
+			user.SelectedSubjectID = subject.ID
+			user.SelectedSubject = ""
+		})
+		popupIfSucceeded = fmt.Sprintf(`Выбран предмет "%s"`, subject.Name)
+	}
 
-	popupIfSucceeded := fmt.Sprintf(`Выбран предмет "%s"`, callbackQuery.Data)
 	popupIfAlreadyAnswered := fmt.Sprintf(`Для смены предмета, воспользуйтесь кнопкой "%s"`, commandSelectSubject)
 
 	return b.updateMessageAfterSelect(callbackQuery, popupIfSucceeded, popupIfAlreadyAnswered, "📖️")
 }
 
 func (b *Bot) selectLevel(callbackQuery *tgbotapi.CallbackQuery) bool {
-	userSelectedLevel[callbackQuery.From.ID] = callbackQuery.Data
+	if callbackQuery.Data != labelAnswered {
+		b.updateUser(callbackQuery.From.ID, func(user *state.User) {
+			user.SelectedLevel = callbackQuery.Data
+		})
+	}
 
 	popupIfSucceeded := fmt.Sprintf(`Выбрана сложность "%s"`, callbackQuery.Data)
 	popupIfAlreadyAnswered := fmt.Sprintf(`Для смены сложности, воспользуйтесь кнопкой "%s"`, commandSelectLevel)
@@ -225,93 +429,120 @@ func (b *Bot) updateInlineQuestion(callbackQuery *tgbotapi.CallbackQuery) bool {
 	chatID := callbackQuery.Message.Chat.ID
 	messageID := callbackQuery.Message.MessageID
 
-	alreadyAnswered := false
-	var popupText string
-	if callbackQuery.Data == labelAnswered {
-		alreadyAnswered = true
-		popupText = "К сожалению изменить ответ нельзя"
-	} else if strings.HasPrefix(callbackQuery.Data, collection.ExplanationPrefix) {
-		alreadyAnswered = true
-		tgMessage := tgbotapi.NewMessage(chatID, fmt.Sprintf("<b>%s</b>\n%s", textExplanation, callbackQuery.Data))
+	if callbackQuery.Data == labelAnswered || !hasCallbackData(callbackQuery.Message.ReplyMarkup, callbackQuery.Data) {
+		b.sendCallback(callbackQuery.ID, "К сожалению изменить ответ нельзя")
+		return false
+	}
+	kind, taskID, optionKey, ok := b.callbacks.verify(callbackQuery.Data)
+	task, found := b.database().GetTask(taskID)
+	if !ok || !found {
+		b.sendCallback(callbackQuery.ID, fmt.Sprintf(`Задание устарело, воспользуйтесь кнопкой "%s"`, commandNext))
+		return false
+	}
+
+	if kind == tokenKindExplanation {
+		tgMessage := tgbotapi.NewMessage(chatID, render.HTML(render.Lines(
+			render.Bold(render.Text(textExplanation)),
+			render.Text(collection.ExplanationPrefix+task.Options[task.Answer]),
+		)))
 		tgMessage.ReplyToMessageID = messageID
 		tgMessage.ParseMode = tgbotapi.ModeHTML
 		b.sendWithAlertOnError(tgMessage)
-	} else {
-		tgKeyboardUpdate := tgbotapi.NewEditMessageText(chatID, messageID, callbackQuery.Message.Text)
-		endQuestionIndex := strings.Index(tgKeyboardUpdate.Text, "\n\n")
-		if endQuestionIndex >= 0 {
-			tgKeyboardUpdate.Text = "<b>" + strings.Replace(tgKeyboardUpdate.Text, "\n\n", "</b>\n\n", 1)
-		} else {
-			tgKeyboardUpdate.Text = "<b>" + tgKeyboardUpdate.Text + "</b>"
-		}
-		tgKeyboardUpdate.ParseMode = tgbotapi.ModeHTML
+		b.sendCallback(callbackQuery.ID, "")
+		return false
+	}
 
-		tgRows := make([][]tgbotapi.InlineKeyboardButton, 0, len(callbackQuery.Message.ReplyMarkup.InlineKeyboard))
-		correctOptionText := "?"
-		hasMistake := false
-		for _, row := range callbackQuery.Message.ReplyMarkup.InlineKeyboard {
-			tgButtons := make([]tgbotapi.InlineKeyboardButton, 0, len(row))
-			for _, button := range row {
-				if button.CallbackData == nil {
-					continue
-				}
-				data := strings.Split(*button.CallbackData, ":")
-				if len(data) != 2 {
-					continue
-				}
-				tgButton := tgbotapi.NewInlineKeyboardButtonData(button.Text, labelAnswered)
-				if data[1] == "true" {
-					correctOptionText = tgButton.Text
-					tgButton.Text += " ✅"
-				} else if *button.CallbackData == callbackQuery.Data {
-					tgButton.Text += " ❌"
-					hasMistake = true
-				}
-				tgButtons = append(tgButtons, tgButton)
+	tgKeyboardUpdate := tgbotapi.NewEditMessageText(chatID, messageID, getTaskMessageHTML(callbackQuery.Message))
+	tgKeyboardUpdate.ParseMode = tgbotapi.ModeHTML
+
+	tgRows := make([][]tgbotapi.InlineKeyboardButton, 0, len(callbackQuery.Message.ReplyMarkup.InlineKeyboard))
+	correctOptionText := "?"
+	correct := optionKey == task.Answer
+	for _, row := range callbackQuery.Message.ReplyMarkup.InlineKeyboard {
+		tgButtons := make([]tgbotapi.InlineKeyboardButton, 0, len(row))
+		for _, button := range row {
+			if button.CallbackData == nil {
+				continue
 			}
-			tgRows = append(tgRows, tgbotapi.NewInlineKeyboardRow(tgButtons...))
-		}
-		if hasMistake {
-			popupText = collection.ExplanationPrefix + correctOptionText
+			_, _, buttonOptionKey, ok := b.callbacks.verify(*button.CallbackData)
+			if !ok {
+				continue
+			}
+			tgButton := tgbotapi.NewInlineKeyboardButtonData(button.Text, labelAnswered)
+			if buttonOptionKey == task.Answer {
+				correctOptionText = tgButton.Text
+				tgButton.Text += " ✅"
+			} else if *button.CallbackData == callbackQuery.Data {
+				tgButton.Text += " ❌"
+			}
+			tgButtons = append(tgButtons, tgButton)
 		}
-		tgRows = append(
-			tgRows,
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData(
-					textExplanation,
-					collection.ExplanationPrefix+correctOptionText,
-				),
-			),
-		)
-		tgKeyboard := tgbotapi.NewInlineKeyboardMarkup(tgRows...)
-		tgKeyboardUpdate.ReplyMarkup = &tgKeyboard
-		b.sendWithAlertOnError(tgKeyboardUpdate)
+		tgRows = append(tgRows, tgbotapi.NewInlineKeyboardRow(tgButtons...))
+	}
+	var popupText string
+	if !correct {
+		popupText = collection.ExplanationPrefix + correctOptionText
 	}
+	b.saveAnswer(callbackQuery.From.ID, task.ID, correct)
+	tgRows = append(
+		tgRows,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(textExplanation, b.callbacks.signExplanation(task.ID)),
+		),
+	)
+	tgKeyboard := tgbotapi.NewInlineKeyboardMarkup(tgRows...)
+	tgKeyboardUpdate.ReplyMarkup = &tgKeyboard
+	b.sendWithAlertOnError(tgKeyboardUpdate)
 
 	b.sendCallback(callbackQuery.ID, popupText)
-	return !alreadyAnswered
+	return true
+}
+
+// hasCallbackData reports whether the keyboard still has a button with the data.
+// A callback query carries the current message, so a button pressed on a stale
+// keyboard is missing from it once the question is answered.
+func hasCallbackData(tgKeyboard *tgbotapi.InlineKeyboardMarkup, data string) bool {
+	if tgKeyboard == nil {
+		return false
+	}
+	for _, row := range tgKeyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.CallbackData != nil && *button.CallbackData == data {
+				return true
+			}
+		}
+	}
+	return false
 }
 
 func (b *Bot) getStartMenu(chatID int64, tgUser *tgbotapi.User) tgbotapi.Chattable {
 	tgMessage := tgbotapi.NewMessage(chatID, getWelcomeText(tgUser))
-	tgButtons := []tgbotapi.KeyboardButton{
-		tgbotapi.NewKeyboardButton(commandSelectSubject),
-		tgbotapi.NewKeyboardButton(commandSelectLevel),
-		tgbotapi.NewKeyboardButton(commandNext),
-	}
-	tgMessage.ReplyMarkup = tgbotapi.NewReplyKeyboard(tgButtons)
+	tgMessage.ReplyMarkup = tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(commandSelectSubject),
+			tgbotapi.NewKeyboardButton(commandSelectLevel),
+			tgbotapi.NewKeyboardButton(commandNext),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(commandSelectTheme),
+			tgbotapi.NewKeyboardButton(commandReview),
+			tgbotapi.NewKeyboardButton(commandExam),
+		),
+	)
 	return &tgMessage
 }
 
 func (b *Bot) getSubjectsList(chatID int64) tgbotapi.Chattable {
 	tgMessage := tgbotapi.NewMessage(chatID, textSelectSubject)
-	tgRows := make([][]tgbotapi.InlineKeyboardButton, 0, len(collection.AllSubjectNames))
-	tgButtons := make([]tgbotapi.InlineKeyboardButton, 0, len(collection.AllSubjectNames))
-	for _, subjectName := range collection.AllSubjectNames {
-		if subject, found := b.database.Subjects[subjectName]; found && len(subject.Tasks) == 0 {
+	database := b.database()
+	tgRows := make([][]tgbotapi.InlineKeyboardButton, 0, len(database.SubjectIDs))
+	tgButtons := make([]tgbotapi.InlineKeyboardButton, 0, len(database.SubjectIDs))
+	for _, subjectID := range database.SubjectIDs {
+		subject := database.Subjects[subjectID]
+		if len(subject.Tasks) == 0 {
 			continue
 		}
-		tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData(subjectName, subjectName))
+		tgButtons = append(tgButtons, tgbotapi.NewInlineKeyboardButtonData(subject.Name, subject.ID))
 	}
 	tgRow := make([]tgbotapi.InlineKeyboardButton, 0)
 	for i := range tgButtons {
@@ -338,72 +569,195 @@ func (b *Bot) getLevelsList(chatID int64) tgbotapi.Chattable {
 func (b *Bot) sendCallback(callbackID, callbackText string) bool {
 	tgCallback := tgbotapi.NewCallback(callbackID, callbackText)
 	if _, err := b.api.Request(tgCallback); err != nil {
-		b.sendAlert(err.Error())
+		b.sendAlert("Error on answering callback query", logging.Err(err))
 		return false
 	}
 	return true
 }
 
 func (b *Bot) sendNextTask(chatID int64, userID int) {
-	for {
-		subjectName := userSelectedSubject[userID]
-		level := userSelectedLevel[userID]
-		var task tgbotapi.Chattable
-		if subject, found := b.database.Subjects[subjectName]; found {
-			task = b.getNextTaskByLevel(subject, chatID, level)
-		} else {
-			task = b.getSubjectsList(chatID)
+	user := b.loadUser(userID)
+	if user.Exam != nil {
+		b.sendNextExamTask(chatID, userID)
+		return
+	}
+	subject, found := b.getSelectedSubject(user)
+	if !found {
+		b.sendWithAlertOnError(b.getSubjectsList(chatID))
+		return
+	}
+	task := b.popDueReviewTask(userID, subject.ID)
+	for attempt := 0; attempt < maxSendTaskAttempts; attempt++ {
+		if task == nil {
+			task = b.getNextTaskByLevel(subject, user.SelectedLevel, user.SelectedThemeCode)
 		}
-		if b.sendWithAlertOnError(task) {
+		if task == nil {
 			break
 		}
+		if err := b.sendTask(chatID, userID, task); err == nil || !isTaskError(err) {
+			return
+		}
+		task = nil
+	}
+	b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, textTaskNotSent))
+}
+
+func (b *Bot) makeTelegramMessage(chatID int64, task *collection.Task) tgbotapi.Chattable {
+	return task.MakeTelegramMessage(chatID, func(key string) string {
+		return b.callbacks.signAnswer(task.ID, key)
+	})
+}
+
+// sendTask sends the task as a poll or as an inline message if the poll is
+// rejected by Telegram. A rejected poll is not tried again for the task.
+// Failures caused by the task itself are counted to quarantine it.
+func (b *Bot) sendTask(chatID int64, userID int, task *collection.Task) error {
+	if task.SendAsPoll && !b.quarantine.isPollRejected(task.ID) {
+		err := b.sendPoll(chatID, userID, task)
+		if err == nil {
+			b.handleTaskSent(task)
+			return nil
+		}
+		if !isTaskError(err) {
+			b.sendAlert("Error on sending poll", logging.Int("taskId", task.ID), logging.Err(err))
+			return err
+		}
+		b.quarantine.rejectPoll(task.ID)
+		b.sendAlert("Poll is rejected, task is sent as message", logging.Int("taskId", task.ID), logging.Err(err))
+	}
+	if _, err := b.api.Send(b.makeTelegramMessage(chatID, task)); err != nil {
+		b.sendAlert("Error on sending task", logging.Int("taskId", task.ID), logging.Err(err))
+		if isTaskError(err) {
+			b.recordTaskFailure(task.ID, err)
+		}
+		return err
+	}
+	b.handleTaskSent(task)
+	return nil
+}
+
+func (b *Bot) sendPoll(chatID int64, userID int, task *collection.Task) error {
+	tgPoll := task.MakeTelegramPoll(chatID)
+	tgMessage, err := b.api.Send(tgPoll)
+	if err != nil {
+		return err
+	}
+	if tgMessage.Poll != nil {
+		poll := &state.Poll{
+			ID:              tgMessage.Poll.ID,
+			TaskID:          task.ID,
+			CorrectOptionID: int(tgPoll.CorrectOptionID),
+			UserID:          userID,
+			ChatID:          chatID,
+			SentAt:          time.Now(),
+		}
+		if err := b.store.SavePoll(poll); err != nil {
+			b.sendAlert("Error on saving poll", logging.String("pollId", poll.ID), logging.Err(err))
+		}
 	}
+	return nil
+}
+
+func (b *Bot) handleTaskSent(task *collection.Task) {
+	b.metrics.observeTaskSent(task)
+	b.clearTaskFailure(task.ID)
 }
 
-func (b *Bot) getNextTaskByLevel(subject *collection.Subject, chatID int64, level string) tgbotapi.Chattable {
+func (b *Bot) getNextTaskByLevel(subject *collection.Subject, level, themeCode string) *collection.Task {
 	switch level {
 	case collection.LevelHigh.String():
-		if len(subject.HighLevelTasks) > 0 {
-			return b.getNextTask(subject.HighLevelTasks, chatID)
+		if tasks := b.availableTasks(subject.GetTasks(collection.LevelHigh, themeCode)); len(tasks) > 0 {
+			return b.getNextTask(tasks)
 		}
 		fallthrough
 	case collection.LevelMedium.String():
-		if len(subject.MediumLevelTasks) > 0 {
-			return b.getNextTask(subject.MediumLevelTasks, chatID)
+		if tasks := b.availableTasks(subject.GetTasks(collection.LevelMedium, themeCode)); len(tasks) > 0 {
+			return b.getNextTask(tasks)
 		}
 		fallthrough
 	case collection.LevelLow.String():
-		if len(subject.LowLevelTasks) > 0 {
-			return b.getNextTask(subject.LowLevelTasks, chatID)
+		if tasks := b.availableTasks(subject.GetTasks(collection.LevelLow, themeCode)); len(tasks) > 0 {
+			return b.getNextTask(tasks)
 		}
 		fallthrough
 	default:
-		return b.getNextTask(subject.Tasks, chatID)
+		if node, found := subject.GetCodifierNode(themeCode); found {
+			if tasks := b.availableTasks(node.Tasks); len(tasks) > 0 {
+				return b.getNextTask(tasks)
+			}
+		}
+		return b.getNextTask(b.availableTasks(subject.Tasks))
 	}
 }
 
-func (b *Bot) getNextTask(tasks []*collection.Task, chatID int64) tgbotapi.Chattable {
-	task := tasks[rand.Intn(len(tasks))]
-	if task.SendAsPoll {
-		return task.MakeTelegramPoll(chatID)
-	} else {
-		return task.MakeTelegramMessage(chatID)
+// getNextTask returns a random task or nil if there are no tasks.
+func (b *Bot) getNextTask(tasks []*collection.Task) *collection.Task {
+	if len(tasks) == 0 {
+		return nil
 	}
+	return tasks[rand.Intn(len(tasks))]
 }
 
-func (b *Bot) sendWithAlertOnError(tgChattable tgbotapi.Chattable) bool {
-	if _, err := b.api.Send(tgChattable); err != nil {
-		b.sendAlert(fmt.Sprintf("Error on sending %v: %s", tgChattable, err))
-		return false
+func (b *Bot) saveAnswer(userID, taskID int, correct bool) {
+	answer := &state.Answer{
+		UserID:     userID,
+		TaskID:     taskID,
+		Correct:    correct,
+		AnsweredAt: time.Now(),
 	}
-	return true
+	b.metrics.observeAnswer(correct)
+	if err := b.store.SaveAnswer(answer); err != nil {
+		b.sendAlert("Error on saving answer", logging.UserID(userID), logging.Int("taskId", taskID), logging.Err(err))
+	}
+	b.scheduleReview(userID, taskID, correct)
+}
+
+func (b *Bot) getSelectedSubject(user *state.User) (*collection.Subject, bool) {
+	return getSelectedSubject(b.database(), user)
+}
+
+// getSelectedSubject returns the subject selected by the user, also if it was
+// selected by name before subjects were selected by ID.
+func getSelectedSubject(database *collection.Database, user *state.User) (*collection.Subject, bool) {
+	if subject, found := database.GetSubjectByID(user.SelectedSubjectID); found {
+		return subject, true
+	}
+	if user.SelectedSubject != "" {
+		return database.GetSubjectByName(user.SelectedSubject)
+	}
+	return nil, false
 }
 
-func (b *Bot) sendAlert(text string) {
-	log.Print(text)
-	tgMessage := tgbotapi.NewMessage(AlertsChatID, fmt.Sprintf("[%s] %s", b.hostName, text))
-	_, err := b.api.Send(tgMessage)
+func (b *Bot) loadUser(userID int) *state.User {
+	user, err := b.sessions.Load(userID)
 	if err != nil {
-		log.Printf("Error on sending alert: %s", err)
+		b.sendAlert("Error on loading user", logging.UserID(userID), logging.Err(err))
+		return &state.User{ID: userID}
+	}
+	return user
+}
+
+func (b *Bot) updateUser(userID int, update func(user *state.User)) {
+	if err := b.sessions.Update(userID, update); err != nil {
+		b.sendAlert("Error on updating user", logging.UserID(userID), logging.Err(err))
+	}
+}
+
+func (b *Bot) sendWithAlertOnError(tgChattable tgbotapi.Chattable) bool {
+	_, ok := b.sendMessageWithAlertOnError(tgChattable)
+	return ok
+}
+
+func (b *Bot) sendMessageWithAlertOnError(tgChattable tgbotapi.Chattable) (tgbotapi.Message, bool) {
+	tgMessage, err := b.api.Send(tgChattable)
+	if err != nil {
+		b.sendAlert(
+			"Error on sending",
+			logging.String("method", getChattableMethod(tgChattable)),
+			logging.TaskBody("request", fmt.Sprintf("%v", tgChattable)),
+			logging.Err(err),
+		)
+		return tgMessage, false
 	}
+	return tgMessage, true
 }
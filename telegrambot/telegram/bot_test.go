@@ -0,0 +1,460 @@
+package telegram
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+const (
+	testUserID      = 42
+	testChatID      = 4242
+	testAdminID     = 7
+	testSubjectID   = "physics"
+	testSubjectName = "Физика"
+
+	testConfig = `{"subjects": [{"id": "physics", "name": "Физика", "path": "physics.json", "order": 1, "enabled": true}]}`
+	testTasks  = `{"tasks": [
+		{"id": 1, "level": 1, "text": "Сколько будет 2 + 2?", "answer": "2", "options": {"1": "3", "2": "4", "3": "5"}},
+		{"id": 2, "level": 2, "text": "Земля круглая?", "answer": "a", "options": {"a": "Да", "b": "Нет"}, "sendAsPoll": true}
+	]}`
+)
+
+type testBot struct {
+	*Bot
+	t      *testing.T
+	client *fakeClient
+	store  state.Store
+	dir    string
+}
+
+func newTestBot(t *testing.T) *testBot {
+	dir, err := ioutil.TempDir("", "telegram")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"subjects.json": testConfig, "physics.json": testTasks} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loader, err := collection.NewLoader(filepath.Join(dir, "subjects.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := state.NewMemoryStore()
+	client := newFakeClient()
+	bot := NewBot(loader, store)
+	bot.api = client
+	bot.callbacks = newCallbackSigner("test")
+	bot.UseAdmins([]int{testAdminID})
+	return &testBot{Bot: bot, t: t, client: client, store: store, dir: dir}
+}
+
+func (b *testBot) close() {
+	_ = os.RemoveAll(b.dir)
+}
+
+func (b *testBot) sendText(text string) []tgbotapi.Chattable {
+	tgMessage := &tgbotapi.Message{
+		From: &tgbotapi.User{ID: testUserID},
+		Chat: &tgbotapi.Chat{ID: testChatID},
+		Text: text,
+	}
+	if strings.HasPrefix(text, "/") {
+		tgMessage.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(text)}}
+	}
+	b.handleUpdate(tgbotapi.Update{Message: tgMessage})
+	return b.client.takeSent()
+}
+
+// press presses the button with the text on the inline keyboard of the message.
+func (b *testBot) press(tgMessage *tgbotapi.MessageConfig, buttonText string) []tgbotapi.Chattable {
+	return b.pressData(tgMessage, buttonData(b.t, tgMessage, buttonText))
+}
+
+func (b *testBot) pressData(tgMessage *tgbotapi.MessageConfig, data string) []tgbotapi.Chattable {
+	return b.pressOn(tgMessage.Text, tgMessage.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup), data)
+}
+
+// pressOn presses a button with the data while the message has the text and the keyboard.
+func (b *testBot) pressOn(text string, tgKeyboard tgbotapi.InlineKeyboardMarkup, data string) []tgbotapi.Chattable {
+	b.handleUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "callback",
+		From: &tgbotapi.User{ID: testUserID},
+		Message: &tgbotapi.Message{
+			MessageID:   1,
+			Chat:        &tgbotapi.Chat{ID: testChatID},
+			Text:        formatPlainText(text),
+			ReplyMarkup: &tgKeyboard,
+		},
+		Data: data,
+	}})
+	return b.client.takeSent()
+}
+
+func buttonData(t *testing.T, tgMessage *tgbotapi.MessageConfig, buttonText string) string {
+	t.Helper()
+	tgKeyboard := tgMessage.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	for _, row := range tgKeyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == buttonText {
+				return *button.CallbackData
+			}
+		}
+	}
+	t.Fatalf("button %q not found in %v", buttonText, tgKeyboard)
+	return ""
+}
+
+func (b *testBot) answerPoll(pollID string, optionID int) []tgbotapi.Chattable {
+	b.handleUpdate(tgbotapi.Update{PollAnswer: &tgbotapi.PollAnswer{
+		PollID:    pollID,
+		User:      tgbotapi.User{ID: testUserID},
+		OptionIDs: []int{optionID},
+	}})
+	return b.client.takeSent()
+}
+
+func TestConversationFlow(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	sent := b.sendText("/start")
+	if len(sent) != 2 {
+		t.Fatalf("expected start menu and subjects list on /start, got %d messages", len(sent))
+	}
+	subjects := findMessage(t, sent, textSelectSubject)
+
+	sent = b.press(subjects, testSubjectName)
+	assertEditedWith(t, sent, testSubjectName+" 📖️")
+	assertCallbackAnswered(t, sent, `Выбран предмет "Физика"`)
+	if user := b.loadUser(testUserID); user.SelectedSubjectID != testSubjectID {
+		t.Fatalf("expected subject %q to be selected, got %q", testSubjectID, user.SelectedSubjectID)
+	}
+
+	levels := findMessage(t, b.sendText(commandSelectLevel), textSelectLevel)
+	sent = b.press(levels, collection.LevelLow.String())
+	assertCallbackAnswered(t, sent, `Выбрана сложность "Базовая"`)
+	task := findMessage(t, sent, "Сколько будет 2 + 2?")
+	if !strings.Contains(task.Text, testSubjectName) {
+		t.Errorf("expected task text to contain the subject name, got %q", task.Text)
+	}
+
+	sent = b.press(task, optionButtonText(t, task, "4"))
+	edit := findEdit(t, sent)
+	if !strings.Contains(edit.Text, "<b>") {
+		t.Errorf("expected question to stay bold, got %q", edit.Text)
+	}
+	assertCallbackAnswered(t, sent, "")
+	assertAnswers(t, b.store, state.Answer{UserID: testUserID, TaskID: 1, Correct: true})
+
+	// The message is edited, so a button pressed on a stale keyboard is not answered again.
+	sent = b.pressOn(edit.Text, *edit.ReplyMarkup, buttonData(t, task, optionButtonText(t, task, "5")))
+	assertCallbackAnswered(t, sent, "К сожалению изменить ответ нельзя")
+	assertAnswers(t, b.store, state.Answer{UserID: testUserID, TaskID: 1, Correct: true})
+
+	task = findMessage(t, b.sendText(commandNext), "Сколько будет 2 + 2?")
+	sent = b.press(task, optionButtonText(t, task, "5"))
+	assertCallbackAnswered(t, sent, collection.ExplanationPrefix+optionButtonText(t, task, "4"))
+	assertAnswers(t, b.store,
+		state.Answer{UserID: testUserID, TaskID: 1, Correct: true},
+		state.Answer{UserID: testUserID, TaskID: 1, Correct: false},
+	)
+	if _, err := b.store.LoadReview(testUserID, 1); err != nil {
+		t.Errorf("expected wrongly answered task to be scheduled for review: %v", err)
+	}
+
+	sent = b.pressData(task, labelAnswered)
+	assertCallbackAnswered(t, sent, "К сожалению изменить ответ нельзя")
+}
+
+func TestPollFlow(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	b.updateUser(testUserID, func(user *state.User) {
+		user.ChatID = testChatID
+		user.SelectedSubjectID = testSubjectID
+		user.SelectedLevel = collection.LevelMedium.String()
+	})
+
+	sent := b.sendText(commandNext)
+	if len(sent) != 1 {
+		t.Fatalf("expected one poll, got %d messages", len(sent))
+	}
+	tgPoll, ok := sent[0].(*tgbotapi.SendPollConfig)
+	if !ok {
+		t.Fatalf("expected poll, got %T", sent[0])
+	}
+	poll, err := b.store.LoadPoll("1")
+	if err != nil {
+		t.Fatalf("expected sent poll to be saved: %v", err)
+	}
+	if poll.TaskID != 2 || poll.CorrectOptionID != int(tgPoll.CorrectOptionID) {
+		t.Errorf("unexpected saved poll %+v", poll)
+	}
+
+	sent = b.answerPoll(poll.ID, poll.CorrectOptionID)
+	if len(sent) != 1 {
+		t.Fatalf("expected next task after poll answer, got %d messages", len(sent))
+	}
+	assertAnswers(t, b.store, state.Answer{UserID: testUserID, TaskID: 2, Correct: true})
+	if _, err := b.store.LoadPoll(poll.ID); err != state.ErrNotFound {
+		t.Errorf("expected answered poll to be deleted, got %v", err)
+	}
+
+	if sent := b.answerPoll(poll.ID, poll.CorrectOptionID); len(sent) != 0 {
+		t.Errorf("expected repeated answer to be ignored, got %d messages", len(sent))
+	}
+}
+
+func TestNextTaskWithoutSubject(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	b.sendText("/start")
+	findMessage(t, b.sendText(commandNext), textSelectSubject)
+}
+
+func TestSubjectSelectedByName(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	b.updateUser(testUserID, func(user *state.User) {
+		user.ChatID = testChatID
+		user.SelectedSubject = testSubjectName
+		user.SelectedLevel = collection.LevelLow.String()
+	})
+
+	findMessage(t, b.sendText(commandNext), "Сколько будет 2 + 2?")
+	subjects := findMessage(t, b.sendText(commandSelectSubject), textSelectSubject)
+	b.press(subjects, testSubjectName)
+	if user := b.loadUser(testUserID); user.SelectedSubjectID != testSubjectID || user.SelectedSubject != "" {
+		t.Errorf("expected subject to be selected by ID, got %+v", user)
+	}
+}
+
+func TestTaskMessageHTML(t *testing.T) {
+	testCases := []struct {
+		tgMessage tgbotapi.Message
+		expected  string
+	}{
+		{
+			tgMessage: tgbotapi.Message{
+				Text:     "Физика\nx < y?\n\n1. a & b",
+				Entities: []tgbotapi.MessageEntity{{Type: "bold", Offset: 0, Length: 13}},
+			},
+			expected: "<b>Физика\nx &lt; y?</b>\n\n1. a &amp; b",
+		},
+		{
+			tgMessage: tgbotapi.Message{Text: "x < y?\n\n1. a & b"},
+			expected:  "<b>x &lt; y?</b>\n\n1. a &amp; b",
+		},
+	}
+	for _, testCase := range testCases {
+		if html := getTaskMessageHTML(&testCase.tgMessage); html != testCase.expected {
+			t.Errorf("expected %q, got %q", testCase.expected, html)
+		}
+	}
+}
+
+// TestConcurrentUpdates handles messages, button presses and poll answers of
+// several users at once, and of one user by several goroutines, as a stress test
+// of sessions for go test -race.
+func TestConcurrentUpdates(t *testing.T) {
+	const (
+		usersCount     = 3
+		workersPerUser = 2
+		iterations     = 10
+	)
+	b := newTestBot(t)
+	defer b.close()
+	for i := 0; i < usersCount; i++ {
+		userID := testUserID + i
+		b.updateUser(userID, func(user *state.User) {
+			user.ChatID = int64(userID)
+			user.SelectedSubjectID = testSubjectID
+			user.SelectedLevel = collection.LevelLow.String()
+		})
+	}
+	answerData := b.callbacks.signAnswer(1, "2")
+	tgKeyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("4", answerData)))
+
+	var wg sync.WaitGroup
+	for i := 0; i < usersCount; i++ {
+		for worker := 0; worker < workersPerUser; worker++ {
+			wg.Add(1)
+			go func(userID, worker int) {
+				defer wg.Done()
+				tgUser := &tgbotapi.User{ID: userID}
+				tgChat := &tgbotapi.Chat{ID: int64(userID)}
+				for iteration := 0; iteration < iterations; iteration++ {
+					pollID := fmt.Sprintf("%d-%d-%d", userID, worker, iteration)
+					if err := b.store.SavePoll(&state.Poll{ID: pollID, TaskID: 2, UserID: userID, ChatID: tgChat.ID, SentAt: time.Now()}); err != nil {
+						t.Error(err)
+						return
+					}
+					b.handleUpdate(tgbotapi.Update{Message: &tgbotapi.Message{From: tgUser, Chat: tgChat, Text: commandNext}})
+					b.handleUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+						ID:   pollID,
+						From: tgUser,
+						Message: &tgbotapi.Message{
+							MessageID:   iteration + 1,
+							Chat:        tgChat,
+							Text:        formatPlainText("Сколько будет 2 + 2?"),
+							ReplyMarkup: &tgKeyboard,
+						},
+						Data: answerData,
+					}})
+					b.handleUpdate(tgbotapi.Update{PollAnswer: &tgbotapi.PollAnswer{PollID: pollID, User: *tgUser, OptionIDs: []int{0}}})
+				}
+			}(testUserID+i, worker)
+		}
+	}
+	wg.Wait()
+
+	if alerts := b.client.takeAlerts(); len(alerts) != 0 {
+		t.Errorf("expected no alerts, got %q", alerts)
+	}
+	for i := 0; i < usersCount; i++ {
+		answers, err := b.store.LoadAnswers(testUserID + i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := 2 * workersPerUser * iterations; len(answers) != expected {
+			t.Errorf("user %d: expected %d answers, got %d", testUserID+i, expected, len(answers))
+		}
+		for _, answer := range answers {
+			if !answer.Correct {
+				t.Errorf("user %d: expected only correct answers, got %+v", testUserID+i, answer)
+			}
+		}
+	}
+}
+
+func findMessage(t *testing.T, sent []tgbotapi.Chattable, text string) *tgbotapi.MessageConfig {
+	t.Helper()
+	for _, tgChattable := range sent {
+		if tgMessage, ok := tgChattable.(*tgbotapi.MessageConfig); ok && strings.Contains(tgMessage.Text, text) {
+			return tgMessage
+		}
+	}
+	t.Fatalf("message with %q not found in %d sent", text, len(sent))
+	return nil
+}
+
+func findEdit(t *testing.T, sent []tgbotapi.Chattable) tgbotapi.EditMessageTextConfig {
+	t.Helper()
+	for _, tgChattable := range sent {
+		if tgEdit, ok := tgChattable.(tgbotapi.EditMessageTextConfig); ok {
+			return tgEdit
+		}
+	}
+	t.Fatalf("message edit not found in %d sent", len(sent))
+	return tgbotapi.EditMessageTextConfig{}
+}
+
+func assertEditedWith(t *testing.T, sent []tgbotapi.Chattable, buttonText string) {
+	t.Helper()
+	tgEdit := findEdit(t, sent)
+	for _, row := range tgEdit.ReplyMarkup.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == buttonText {
+				return
+			}
+		}
+	}
+	t.Errorf("button %q not found in edited keyboard %v", buttonText, tgEdit.ReplyMarkup.InlineKeyboard)
+}
+
+func assertCallbackAnswered(t *testing.T, sent []tgbotapi.Chattable, text string) {
+	t.Helper()
+	for _, tgChattable := range sent {
+		if tgCallback, ok := tgChattable.(tgbotapi.CallbackConfig); ok {
+			if tgCallback.Text != text {
+				t.Errorf("expected callback answer %q, got %q", text, tgCallback.Text)
+			}
+			return
+		}
+	}
+	t.Errorf("callback answer not found in %d sent", len(sent))
+}
+
+func assertAnswers(t *testing.T, store state.Store, expected ...state.Answer) {
+	t.Helper()
+	answers, err := store.LoadAnswers(testUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(answers) != len(expected) {
+		t.Fatalf("expected %d answers, got %d", len(expected), len(answers))
+	}
+	for i := range expected {
+		if answers[i].TaskID != expected[i].TaskID || answers[i].Correct != expected[i].Correct {
+			t.Errorf("answer #%d: expected %+v, got %+v", i, expected[i], answers[i])
+		}
+	}
+}
+
+// optionButtonText returns the text of the button for the option, which is its
+// number in the shuffled list of options.
+func optionButtonText(t *testing.T, tgMessage *tgbotapi.MessageConfig, option string) string {
+	t.Helper()
+	for _, line := range strings.Split(tgMessage.Text, "\n") {
+		if parts := strings.SplitN(line, ". ", 2); len(parts) == 2 && parts[1] == option {
+			return parts[0]
+		}
+	}
+	t.Fatalf("option %q not found in %q", option, tgMessage.Text)
+	return ""
+}
+
+func TestAlertsCarryUpdateID(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	b.handleUpdate(tgbotapi.Update{UpdateID: 5, Message: &tgbotapi.Message{
+		From:     &tgbotapi.User{ID: testUserID},
+		Chat:     &tgbotapi.Chat{ID: testChatID},
+		Text:     "/start",
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/start")}},
+	}})
+	b.client.mutex.Lock()
+	defer b.client.mutex.Unlock()
+	for _, tgChattable := range b.client.sent {
+		if tgMessage, ok := tgChattable.(tgbotapi.MessageConfig); ok && tgMessage.ChatID == AlertsChatID {
+			if !strings.Contains(tgMessage.Text, "updateId=5") {
+				t.Errorf("expected alert to carry the update ID, got %q", tgMessage.Text)
+			}
+			return
+		}
+	}
+	t.Error("expected an alert about the started conversation")
+}
+
+func TestAlertsWithoutErrorAreNotMerged(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	for _, userID := range []int{testUserID, testUserID + 1, testUserID} {
+		b.handleUpdate(tgbotapi.Update{Message: &tgbotapi.Message{
+			From:     &tgbotapi.User{ID: userID},
+			Chat:     &tgbotapi.Chat{ID: int64(userID)},
+			Text:     "/start",
+			Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/start")}},
+		}})
+	}
+	if alerts := b.client.takeAlerts(); len(alerts) != 2 {
+		t.Errorf("expected an alert per started conversation, got %q", alerts)
+	}
+}
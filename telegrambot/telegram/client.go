@@ -0,0 +1,49 @@
+package telegram
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// telegramClient is the part of Telegram Bot API used by the bot. It is
+// implemented by *tgbotapi.BotAPI and replaced by a fake in tests.
+type telegramClient interface {
+	Send(tgChattable tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(tgChattable tgbotapi.Chattable) (tgbotapi.APIResponse, error)
+	GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error)
+	MakeRequest(endpoint string, params tgbotapi.Params) (tgbotapi.APIResponse, error)
+	UploadFile(endpoint string, params tgbotapi.Params, fieldName string, file interface{}) (tgbotapi.APIResponse, error)
+}
+
+// newHTTPClient makes a client for Bot API at the endpoint. The endpoint is
+// applied by the transport, because tgbotapi calls getMe on the official endpoint
+// before it can be changed.
+func newHTTPClient(apiEndpoint string) (*http.Client, error) {
+	if apiEndpoint == "" {
+		return &http.Client{}, nil
+	}
+	endpoint, err := url.Parse(apiEndpoint)
+	if err != nil || endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid Bot API endpoint %q", apiEndpoint)
+	}
+	return &http.Client{Transport: endpointTransport{endpoint: endpoint, base: http.DefaultTransport}}, nil
+}
+
+// endpointTransport sends requests to the endpoint keeping their paths.
+type endpointTransport struct {
+	endpoint *url.URL
+	base     http.RoundTripper
+}
+
+func (t endpointTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.URL.Scheme = t.endpoint.Scheme
+	r.URL.Host = t.endpoint.Host
+	r.URL.Path = strings.TrimSuffix(t.endpoint.Path, "/") + r.URL.Path
+	r.Host = ""
+	return t.base.RoundTrip(r)
+}
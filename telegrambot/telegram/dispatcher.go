@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// dispatcher shards updates between listeners by user, so updates of one user
+// are handled one by one in arrival order while different users run in parallel.
+type dispatcher struct {
+	queues []chan tgbotapi.Update
+	// busySince keeps for every listener the time in unix nanoseconds when it has
+	// started handling the current update, or zero if it is idle.
+	busySince []int64
+	wg        sync.WaitGroup
+}
+
+type listenersStatus struct {
+	Count  int `json:"count"`
+	Busy   int `json:"busy"`
+	Stuck  int `json:"stuck"`
+	Queued int `json:"queued"`
+}
+
+func newDispatcher(listenersCount, queueSize int) *dispatcher {
+	queues := make([]chan tgbotapi.Update, listenersCount)
+	for i := range queues {
+		queues[i] = make(chan tgbotapi.Update, queueSize)
+	}
+	return &dispatcher{
+		queues:    queues,
+		busySince: make([]int64, listenersCount),
+	}
+}
+
+func (d *dispatcher) start(handle func(update tgbotapi.Update)) {
+	for i, queue := range d.queues {
+		d.wg.Add(1)
+		go func(busySince *int64, queue chan tgbotapi.Update) {
+			defer d.wg.Done()
+			for update := range queue {
+				atomic.StoreInt64(busySince, time.Now().UnixNano())
+				handle(update)
+				atomic.StoreInt64(busySince, 0)
+			}
+		}(&d.busySince[i], queue)
+	}
+}
+
+// stop waits for listeners to handle queued updates. Nothing may be dispatched
+// after stop. Returns false if the context is done earlier.
+func (d *dispatcher) stop(ctx context.Context) bool {
+	for _, queue := range d.queues {
+		close(queue)
+	}
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// status counts busy listeners and those handling one update longer than stuckTimeout.
+func (d *dispatcher) status(now time.Time, stuckTimeout time.Duration) listenersStatus {
+	status := listenersStatus{Count: len(d.queues)}
+	for i, queue := range d.queues {
+		status.Queued += len(queue)
+		if busySince := atomic.LoadInt64(&d.busySince[i]); busySince != 0 {
+			status.Busy++
+			if now.Sub(time.Unix(0, busySince)) > stuckTimeout {
+				status.Stuck++
+			}
+		}
+	}
+	return status
+}
+
+func (d *dispatcher) dispatch(update tgbotapi.Update) {
+	shard := getUpdateShardKey(update) % int64(len(d.queues))
+	if shard < 0 {
+		shard = -shard
+	}
+	d.queues[shard] <- update
+}
+
+func getUpdateShardKey(update tgbotapi.Update) int64 {
+	switch {
+	case update.Message != nil && update.Message.From != nil:
+		return int64(update.Message.From.ID)
+	case update.Message != nil:
+		return update.Message.Chat.ID
+	case update.CallbackQuery != nil:
+		return int64(update.CallbackQuery.From.ID)
+	case update.PollAnswer != nil:
+		return int64(update.PollAnswer.User.ID)
+	default:
+		return int64(update.UpdateID)
+	}
+}
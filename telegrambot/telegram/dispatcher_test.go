@@ -0,0 +1,129 @@
+package telegram
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestDispatcherStopDrainsQueues(t *testing.T) {
+	const updatesCount = 50
+	var handled int32
+	d := newDispatcher(3, updatesCount)
+	d.start(func(update tgbotapi.Update) {
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&handled, 1)
+	})
+	for i := 1; i <= updatesCount; i++ {
+		d.dispatch(tgbotapi.Update{UpdateID: i})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !d.stop(ctx) {
+		t.Fatal("expected dispatcher to stop before the deadline")
+	}
+	if handled != updatesCount {
+		t.Errorf("expected %d handled updates, got %d", updatesCount, handled)
+	}
+}
+
+func TestDispatcherStopDeadline(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	d := newDispatcher(1, 1)
+	d.start(func(update tgbotapi.Update) {
+		<-release
+	})
+	d.dispatch(tgbotapi.Update{UpdateID: 1})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if d.stop(ctx) {
+		t.Fatal("expected dispatcher to give up waiting for a stuck handler")
+	}
+}
+
+func TestDispatcherShardsByUser(t *testing.T) {
+	const (
+		slowUserID    = 1
+		updatesCount  = 10
+		listenerCount = 4
+	)
+	userIDs := []int{slowUserID, 2, 3}
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	handled := make(map[int][]int)
+	var running, maxRunning int32
+	d := newDispatcher(listenerCount, len(userIDs)*updatesCount)
+	d.start(func(update tgbotapi.Update) {
+		current := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			seen := atomic.LoadInt32(&maxRunning)
+			if current <= seen || atomic.CompareAndSwapInt32(&maxRunning, seen, current) {
+				break
+			}
+		}
+		userID := int(getUpdateShardKey(update))
+		if userID == slowUserID {
+			<-release
+		}
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		handled[userID] = append(handled[userID], update.UpdateID)
+		mutex.Unlock()
+	})
+
+	// Updates of all kinds from the users are interleaved.
+	expected := make(map[int][]int)
+	updateID := 0
+	for i := 0; i < updatesCount; i++ {
+		for _, userID := range userIDs {
+			updateID++
+			update := tgbotapi.Update{UpdateID: updateID}
+			switch i % 3 {
+			case 0:
+				update.Message = &tgbotapi.Message{From: &tgbotapi.User{ID: userID}, Chat: &tgbotapi.Chat{ID: int64(userID)}}
+			case 1:
+				update.CallbackQuery = &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: userID}}
+			case 2:
+				update.PollAnswer = &tgbotapi.PollAnswer{User: tgbotapi.User{ID: userID}}
+			}
+			d.dispatch(update)
+			expected[userID] = append(expected[userID], updateID)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mutex.Lock()
+		othersHandled := len(handled[2]) == updatesCount && len(handled[3]) == updatesCount
+		mutex.Unlock()
+		if othersHandled {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected updates of other users to be handled while the slow user is blocked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if !d.stop(ctx) {
+		t.Fatal("expected dispatcher to stop before the deadline")
+	}
+
+	if !reflect.DeepEqual(handled, expected) {
+		t.Errorf("expected updates of every user in arrival order %v, got %v", expected, handled)
+	}
+	if maxRunning < 2 {
+		t.Errorf("expected updates of different users to be handled in parallel, got at most %d at once", maxRunning)
+	}
+}
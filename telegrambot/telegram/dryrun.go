@@ -0,0 +1,68 @@
+package telegram
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+const (
+	dryRunToken  = "dry-run"
+	dryRunChatID = 1
+)
+
+// DryRun renders every task the way users get it, both in practice and in exam
+// mode, and sends it to a fake Bot API which checks Bot API constraints. Every
+// rejected request is written to the report. Returns the number of failures.
+func (b *Bot) DryRun(report io.Writer) int {
+	api, err := tgbotapi.NewBotAPIWithClient(dryRunToken, botapi.NewFakeServer().Client())
+	if err != nil {
+		logging.Panic("Error on starting fake Bot API", logging.Err(err))
+	}
+	b.api = api
+	if b.callbacks == nil {
+		b.callbacks = newCallbackSigner(dryRunToken)
+	}
+
+	database := b.database()
+	tasksCount, failuresCount := 0, 0
+	for _, subjectID := range database.SubjectIDs {
+		subject := database.Subjects[subjectID]
+		for _, task := range subject.Tasks {
+			tasksCount++
+			for _, rendering := range b.renderDryRunTask(task) {
+				if _, err := b.api.Send(rendering.chattable); err != nil {
+					failuresCount++
+					_, _ = fmt.Fprintf(report, "%s: task %d: %s: %s\n", subject.Name, task.ID, rendering.name, err)
+				}
+			}
+		}
+	}
+	_, _ = fmt.Fprintf(report, "Checked %d tasks of %d subjects, %d failures\n", tasksCount, len(database.SubjectIDs), failuresCount)
+	return failuresCount
+}
+
+type dryRunRendering struct {
+	name      string
+	chattable tgbotapi.Chattable
+}
+
+func (b *Bot) renderDryRunTask(task *collection.Task) []dryRunRendering {
+	renderings := make([]dryRunRendering, 0, 2)
+	if task.SendAsPoll {
+		renderings = append(renderings, dryRunRendering{"poll", task.MakeTelegramPoll(dryRunChatID)})
+	} else {
+		renderings = append(renderings, dryRunRendering{"message", b.makeTelegramMessage(dryRunChatID, task)})
+	}
+	startedAt := time.Now()
+	exam := task.MakeTelegramExamMessage(dryRunChatID, fmt.Sprintf(textExamTask, 1, 1), func(key string) string {
+		return formatExamCallbackData(startedAt, 0, key)
+	})
+	return append(renderings, dryRunRendering{"exam message", exam})
+}
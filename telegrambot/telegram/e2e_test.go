@@ -0,0 +1,102 @@
+package telegram
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+)
+
+const e2eTimeout = 5 * time.Second
+
+func TestEndToEndWithFakeBotAPI(t *testing.T) {
+	fakeAPI := botapi.NewFakeServer()
+	server := httptest.NewServer(fakeAPI)
+	defer server.Close()
+	defer fakeAPI.Close()
+
+	if err := os.Setenv("BOT_TOKEN", "123:fake"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("BOT_TOKEN")
+
+	b := newTestBot(t)
+	defer b.close()
+	b.Init(server.URL)
+	ctx, stopReceiving := context.WithCancel(context.Background())
+	b.listen(ctx)
+	defer b.shutdown(stopReceiving)
+
+	tgUser := &tgbotapi.User{ID: testUserID, FirstName: "Тест"}
+	tgChat := &tgbotapi.Chat{ID: testChatID}
+	fakeAPI.PushUpdate(tgbotapi.Update{Message: &tgbotapi.Message{
+		MessageID: 1,
+		From:      tgUser,
+		Chat:      tgChat,
+		Text:      "/start",
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len("/start")}},
+	}})
+	calls := waitForUserCalls(t, fakeAPI, 2)
+	if calls[1].Method != "sendMessage" || calls[1].Params.Get("text") != textSelectSubject {
+		t.Fatalf("expected subjects list, got %s %v", calls[1].Method, calls[1].Params)
+	}
+
+	fakeAPI.PushUpdate(tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID:   "1",
+		From: tgUser,
+		Message: &tgbotapi.Message{
+			MessageID:   2,
+			Chat:        tgChat,
+			Text:        textSelectSubject,
+			ReplyMarkup: &tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{{tgbotapi.NewInlineKeyboardButtonData(testSubjectName, testSubjectID)}}},
+		},
+		Data: testSubjectID,
+	}})
+	calls = waitForUserCalls(t, fakeAPI, 5)
+	methods := make([]string, 0, 3)
+	for _, call := range calls[2:] {
+		methods = append(methods, call.Method)
+	}
+	if methods[0] != "editMessageText" || methods[1] != "answerCallbackQuery" || (methods[2] != "sendMessage" && methods[2] != "sendPoll") {
+		t.Fatalf("expected keyboard edit, callback answer and a task, got %v", methods)
+	}
+	if text := calls[3].Params.Get("text"); text != `Выбран предмет "Физика"` {
+		t.Errorf("unexpected callback answer %q", text)
+	}
+	if user := b.loadUser(testUserID); user.SelectedSubjectID != testSubjectID {
+		t.Errorf("expected subject %q to be selected, got %q", testSubjectID, user.SelectedSubjectID)
+	}
+	taskText := calls[4].Params.Get("text") + calls[4].Params.Get("question")
+	if !strings.Contains(taskText, testSubjectName) {
+		t.Errorf("expected task of subject %q, got %q", testSubjectName, taskText)
+	}
+}
+
+// waitForUserCalls waits for calls other than getMe and alerts.
+func waitForUserCalls(t *testing.T, fakeAPI *botapi.FakeServer, count int) []botapi.Call {
+	t.Helper()
+	deadline := time.Now().Add(e2eTimeout)
+	for {
+		calls := make([]botapi.Call, 0)
+		allCalls := fakeAPI.Calls()
+		for _, call := range allCalls {
+			if call.Method != "getMe" && call.Params.Get("chat_id") != strconv.Itoa(AlertsChatID) {
+				calls = append(calls, call)
+			}
+		}
+		if len(calls) >= count {
+			return calls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d calls, got %d: %v", count, len(calls), calls)
+		}
+		fakeAPI.WaitForCalls(len(allCalls)+1, time.Until(deadline))
+	}
+}
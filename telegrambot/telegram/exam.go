@@ -0,0 +1,396 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/logging"
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+const (
+	examDuration       = 45 * time.Minute
+	callbackExamAnswer = "exam"
+	markerExamAnswer   = "📝"
+
+	textExamStarted    = "Экзамен по предмету \"%s\": %d заданий, %d минут. Правильные ответы будут показаны в конце."
+	textExamTask       = "Экзамен: задание %d из %d"
+	textExamAccepted   = "Ответ принят"
+	textExamNotActive  = "Этот экзамен уже завершён"
+	textExamNoTasks    = "Для этого предмета пока нет заданий"
+	textExamReport     = "Экзамен по предмету \"%s\" завершён"
+	textExamTimeIsOver = "Время вышло!"
+	textExamScore      = "Первичный балл: %d из %d"
+	textExamCorrect    = "Верных ответов: %d из %d"
+	textExamDuration   = "Время: %d мин %d сек"
+	textExamThemes     = "Результаты по темам:"
+)
+
+// startExam draws a variant for the selected subject or continues the exam in progress.
+func (b *Bot) startExam(chatID int64, userID int) {
+	user := b.loadUser(userID)
+	if user.Exam != nil {
+		b.sendNextExamTask(chatID, userID)
+		return
+	}
+	subject, found := b.getSelectedSubject(user)
+	if !found {
+		b.sendWithAlertOnError(b.getSubjectsList(chatID))
+		return
+	}
+	variant := subject.MakeExamVariant(b.availableTasks)
+	if len(variant) == 0 {
+		b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, textExamNoTasks))
+		return
+	}
+
+	now := time.Now()
+	exam := &state.Exam{
+		SubjectName: subject.Name,
+		TaskIDs:     make([]int, len(variant)),
+		Results:     make([]state.ExamResult, len(variant)),
+		StartedAt:   now,
+		Deadline:    now.Add(examDuration),
+	}
+	for i, task := range variant {
+		exam.TaskIDs[i] = task.ID
+	}
+	b.updateUser(userID, func(user *state.User) {
+		user.Exam = exam
+	})
+	b.scheduleExamFinish(userID, exam.Deadline)
+
+	text := fmt.Sprintf(textExamStarted, subject.Name, len(variant), int(examDuration.Minutes()))
+	b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, text))
+	b.sendNextExamTask(chatID, userID)
+}
+
+// scheduleExamFinish finishes the exam when its time is over, even if the user
+// doesn't answer anymore.
+func (b *Bot) scheduleExamFinish(userID int, deadline time.Time) {
+	// The exam is finished outside of the update which has started it.
+	bot := b.withLogger(nil)
+	b.examTimers.schedule(userID, deadline, func() {
+		bot.finishExam(userID, true)
+	})
+}
+
+// examTimers finish exams when their time is over. Once stopped, they don't
+// finish exams anymore, those are restored from the store on the next start.
+type examTimers struct {
+	mutex   sync.Mutex
+	timers  map[int]*time.Timer
+	stopped bool
+	running sync.WaitGroup
+}
+
+func newExamTimers() *examTimers {
+	return &examTimers{timers: make(map[int]*time.Timer)}
+}
+
+// schedule calls finish at the deadline instead of the call scheduled for the
+// user before.
+func (t *examTimers) schedule(userID int, deadline time.Time, finish func()) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopped {
+		return
+	}
+	if timer, found := t.timers[userID]; found {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(deadline), func() {
+		t.mutex.Lock()
+		if t.stopped || t.timers[userID] != timer {
+			t.mutex.Unlock()
+			return
+		}
+		delete(t.timers, userID)
+		t.running.Add(1)
+		t.mutex.Unlock()
+		defer t.running.Done()
+		finish()
+	})
+	t.timers[userID] = timer
+}
+
+// stop cancels pending timers and waits for exams being finished. Returns false
+// if the context is done earlier.
+func (t *examTimers) stop(ctx context.Context) bool {
+	t.mutex.Lock()
+	t.stopped = true
+	for userID, timer := range t.timers {
+		timer.Stop()
+		delete(t.timers, userID)
+	}
+	t.mutex.Unlock()
+	done := make(chan struct{})
+	go func() {
+		t.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// restoreExamTimers schedules finishing of exams started before restart. Exams
+// which expired meanwhile are finished right away.
+func (b *Bot) restoreExamTimers() {
+	users, err := b.store.LoadUsersWithExams()
+	if err != nil {
+		b.sendAlert("Error on loading exams", logging.Err(err))
+		return
+	}
+	for _, user := range users {
+		b.scheduleExamFinish(user.ID, user.Exam.Deadline)
+	}
+	if len(users) > 0 {
+		logging.Info("Exam timers are restored", logging.Int("exams", len(users)))
+	}
+}
+
+// sendNextExamTask sends the first unanswered task of the exam in progress or
+// finishes the exam if all tasks are answered or time is over. Tasks rejected by
+// Telegram are skipped.
+func (b *Bot) sendNextExamTask(chatID int64, userID int) {
+	skippedIndex := -1
+	for {
+		exam := b.loadUser(userID).Exam
+		if exam == nil {
+			return
+		}
+		index := exam.NextTaskIndex()
+		if index < 0 || exam.IsExpired(time.Now()) {
+			b.finishExam(userID, false)
+			return
+		}
+		if index == skippedIndex {
+			// The skip was not saved, don't retry the same task forever.
+			b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, textTaskNotSent))
+			return
+		}
+		task, found := b.database().GetTask(exam.TaskIDs[index])
+		if !found {
+			b.sendAlert("Exam task not found", logging.Int("taskId", exam.TaskIDs[index]), logging.UserID(userID))
+			b.finishExam(userID, false)
+			return
+		}
+		header := fmt.Sprintf(textExamTask, index+1, len(exam.TaskIDs))
+		tgMessage := task.MakeTelegramExamMessage(chatID, header, func(key string) string {
+			return formatExamCallbackData(exam.StartedAt, index, key)
+		})
+		_, err := b.api.Send(tgMessage)
+		if err == nil {
+			b.handleTaskSent(task)
+			return
+		}
+		b.sendAlert("Error on sending exam task", logging.Int("taskId", task.ID), logging.Err(err))
+		if !isTaskError(err) {
+			b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, textTaskNotSent))
+			return
+		}
+		b.recordTaskFailure(task.ID, err)
+		skippedIndex = index
+		b.updateUser(userID, func(user *state.User) {
+			if user.Exam != nil && user.Exam.StartedAt.Equal(exam.StartedAt) {
+				user.Exam.Results[index].Skipped = true
+			}
+		})
+	}
+}
+
+func (b *Bot) answerExamTask(callbackQuery *tgbotapi.CallbackQuery) {
+	chatID := callbackQuery.Message.Chat.ID
+	userID := callbackQuery.From.ID
+	startedAt, index, key, ok := parseExamCallbackData(callbackQuery.Data)
+	if !ok {
+		b.sendCallback(callbackQuery.ID, "")
+		return
+	}
+
+	accepted := false
+	active := false
+	b.updateUser(userID, func(user *state.User) {
+		exam := user.Exam
+		if exam == nil || exam.StartedAt.Unix() != startedAt || index < 0 || index >= len(exam.TaskIDs) {
+			return
+		}
+		active = true
+		if exam.Results[index].Answered || exam.IsExpired(time.Now()) {
+			return
+		}
+		task, found := b.database().GetTask(exam.TaskIDs[index])
+		exam.Results[index] = state.ExamResult{
+			Answered: true,
+			Correct:  found && key == task.Answer,
+		}
+		accepted = true
+	})
+
+	if !active {
+		b.sendCallback(callbackQuery.ID, textExamNotActive)
+		return
+	}
+	if accepted {
+		b.markExamAnswer(callbackQuery)
+		b.sendCallback(callbackQuery.ID, textExamAccepted)
+	} else {
+		b.sendCallback(callbackQuery.ID, "")
+	}
+	b.sendNextExamTask(chatID, userID)
+}
+
+// markExamAnswer disables buttons of the answered task and marks the chosen one
+// without revealing whether it was correct.
+func (b *Bot) markExamAnswer(callbackQuery *tgbotapi.CallbackQuery) {
+	tgRows := make([][]tgbotapi.InlineKeyboardButton, 0, len(callbackQuery.Message.ReplyMarkup.InlineKeyboard))
+	for _, row := range callbackQuery.Message.ReplyMarkup.InlineKeyboard {
+		tgButtons := make([]tgbotapi.InlineKeyboardButton, 0, len(row))
+		for _, button := range row {
+			if button.CallbackData == nil {
+				continue
+			}
+			tgButton := tgbotapi.NewInlineKeyboardButtonData(button.Text, labelAnswered)
+			if *button.CallbackData == callbackQuery.Data {
+				tgButton.Text += " " + markerExamAnswer
+			}
+			tgButtons = append(tgButtons, tgButton)
+		}
+		tgRows = append(tgRows, tgButtons)
+	}
+	b.sendWithAlertOnError(tgbotapi.NewEditMessageReplyMarkup(
+		callbackQuery.Message.Chat.ID,
+		callbackQuery.Message.MessageID,
+		tgbotapi.NewInlineKeyboardMarkup(tgRows...),
+	))
+}
+
+// finishExam ends the exam in progress, records its answers and sends the report.
+// If onlyIfExpired is set, the exam is finished only when its time is over.
+func (b *Bot) finishExam(userID int, onlyIfExpired bool) {
+	var exam *state.Exam
+	var chatID int64
+	now := time.Now()
+	b.updateUser(userID, func(user *state.User) {
+		if user.Exam == nil || (onlyIfExpired && !user.Exam.IsExpired(now)) {
+			return
+		}
+		exam = user.Exam
+		chatID = user.ChatID
+		user.Exam = nil
+	})
+	if exam == nil {
+		return
+	}
+
+	for i, result := range exam.Results {
+		if result.Answered {
+			b.saveAnswer(userID, exam.TaskIDs[i], result.Correct)
+		}
+	}
+	// The report of an expired exam is not awaited by the user.
+	client := b.api
+	if onlyIfExpired {
+		client = b.client(priorityBackground)
+	}
+	if _, err := client.Send(b.getExamReport(chatID, exam, now)); err != nil {
+		b.sendAlert("Error on sending exam report", logging.UserID(userID), logging.Err(err))
+	}
+}
+
+func (b *Bot) getExamReport(chatID int64, exam *state.Exam, finishedAt time.Time) tgbotapi.Chattable {
+	score, maxScore, correctCount, scoredCount := 0, 0, 0, 0
+	themes := make(map[string]*accuracy)
+	database := b.database()
+	for i, taskID := range exam.TaskIDs {
+		if exam.Results[i].Skipped {
+			continue
+		}
+		scoredCount++
+		task, found := database.GetTask(taskID)
+		if !found {
+			continue
+		}
+		correct := exam.Results[i].Correct
+		maxScore += task.Level.Points()
+		if correct {
+			score += task.Level.Points()
+			correctCount++
+		}
+		for _, theme := range task.Themes {
+			getAccuracy(themes, theme).add(correct)
+		}
+	}
+
+	lines := []string{fmt.Sprintf(textExamReport, exam.SubjectName)}
+	if exam.IsExpired(finishedAt) {
+		lines = append(lines, textExamTimeIsOver)
+	}
+	if finishedAt.After(exam.Deadline) {
+		finishedAt = exam.Deadline
+	}
+	duration := finishedAt.Sub(exam.StartedAt)
+	lines = append(
+		lines,
+		"",
+		fmt.Sprintf(textExamScore, score, maxScore),
+		fmt.Sprintf(textExamCorrect, correctCount, scoredCount),
+		fmt.Sprintf(textExamDuration, int(duration.Minutes()), int(duration.Seconds())%60),
+	)
+
+	if len(themes) > 0 {
+		lines = append(lines, "", textExamThemes)
+		sortedThemes := make([]string, 0, len(themes))
+		for theme := range themes {
+			sortedThemes = append(sortedThemes, theme)
+		}
+		sort.Slice(sortedThemes, func(i, j int) bool {
+			iCode, _ := collection.ParseThemeCode(sortedThemes[i])
+			jCode, _ := collection.ParseThemeCode(sortedThemes[j])
+			return iCode < jCode
+		})
+		for _, theme := range sortedThemes {
+			lines = append(lines, fmt.Sprintf("%s: %s", formatPlainText(theme), themes[theme]))
+		}
+	}
+
+	tgMessage := tgbotapi.NewMessage(chatID, strings.Join(lines, "\n"))
+	return &tgMessage
+}
+
+func formatExamCallbackData(startedAt time.Time, index int, key string) string {
+	return fmt.Sprintf("%s:%d:%d:%s", callbackExamAnswer, startedAt.Unix(), index, key)
+}
+
+func parseExamCallbackData(data string) (startedAt int64, index int, key string, ok bool) {
+	parts := strings.SplitN(data, ":", 4)
+	if len(parts) != 4 || parts[0] != callbackExamAnswer {
+		return 0, 0, "", false
+	}
+	startedAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, "", false
+	}
+	index, err = strconv.Atoi(parts[2])
+	if err != nil {
+		return 0, 0, "", false
+	}
+	return startedAt, index, parts[3], true
+}
+
+func isExamCallbackData(data string) bool {
+	return strings.HasPrefix(data, callbackExamAnswer+":")
+}
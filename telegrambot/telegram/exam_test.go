@@ -0,0 +1,91 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+func TestRestoreExamTimers(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	startedAt := time.Now().Add(-examDuration - time.Minute)
+	b.updateUser(testUserID, func(user *state.User) {
+		user.ChatID = testChatID
+		user.Exam = &state.Exam{
+			SubjectName: testSubjectName,
+			TaskIDs:     []int{1, 2},
+			Results:     []state.ExamResult{{Answered: true, Correct: true}, {}},
+			StartedAt:   startedAt,
+			Deadline:    startedAt.Add(examDuration),
+		}
+	})
+
+	b.restoreExamTimers()
+	deadline := time.Now().Add(time.Second)
+	for b.loadUser(testUserID).Exam != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("expected exam expired before restart to be finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	report := findMessage(t, b.client.takeSent(), fmt.Sprintf(textExamReport, testSubjectName))
+	if expected := fmt.Sprintf(textExamCorrect, 1, 2); !strings.Contains(report.Text, expected) {
+		t.Errorf("expected %q in report, got %q", expected, report.Text)
+	}
+	assertAnswers(t, b.store, state.Answer{UserID: testUserID, TaskID: 1, Correct: true})
+}
+
+func TestExamTimersStop(t *testing.T) {
+	var finished int32
+	finish := func() { atomic.AddInt32(&finished, 1) }
+	timers := newExamTimers()
+	timers.schedule(testUserID, time.Now().Add(10*time.Millisecond), finish)
+	timers.schedule(testUserID+1, time.Now().Add(time.Hour), finish)
+	// Rescheduling replaces the timer of the user.
+	timers.schedule(testUserID+1, time.Now().Add(10*time.Millisecond), finish)
+	timers.schedule(testUserID, time.Now().Add(time.Hour), finish)
+
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatalf("expected only the rescheduled exam to be finished, got %d", finished)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !timers.stop(ctx) {
+		t.Fatal("expected timers to stop before the deadline")
+	}
+	timers.schedule(testUserID+2, time.Now(), finish)
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Errorf("expected no exams to be finished after stop, got %d", finished)
+	}
+}
+
+func TestExamTimersStopWaitsForFinish(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	timers := newExamTimers()
+	timers.schedule(testUserID, time.Now(), func() {
+		close(started)
+		<-release
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if timers.stop(ctx) {
+		t.Fatal("expected stop to give up waiting for an exam being finished")
+	}
+	close(release)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if !timers.stop(ctx) {
+		t.Error("expected stop to return once the exam is finished")
+	}
+}
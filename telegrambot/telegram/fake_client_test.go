@@ -0,0 +1,87 @@
+package telegram
+
+import (
+	"strconv"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// fakeClient records everything the bot sends instead of calling Bot API.
+type fakeClient struct {
+	mutex         sync.Mutex
+	sent          []tgbotapi.Chattable
+	nextMessageID int
+	// reject returns an error for requests which Send must fail.
+	reject func(tgChattable tgbotapi.Chattable) error
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{nextMessageID: 1}
+}
+
+func (c *fakeClient) Send(tgChattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.reject != nil {
+		if err := c.reject(tgChattable); err != nil {
+			return tgbotapi.Message{}, err
+		}
+	}
+	c.sent = append(c.sent, tgChattable)
+	tgMessage := tgbotapi.Message{MessageID: c.nextMessageID}
+	if _, ok := tgChattable.(*tgbotapi.SendPollConfig); ok {
+		tgMessage.Poll = &tgbotapi.Poll{ID: strconv.Itoa(c.nextMessageID)}
+	}
+	c.nextMessageID++
+	return tgMessage, nil
+}
+
+func (c *fakeClient) Request(tgChattable tgbotapi.Chattable) (tgbotapi.APIResponse, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.sent = append(c.sent, tgChattable)
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (c *fakeClient) GetUpdates(tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	return nil, nil
+}
+
+func (c *fakeClient) MakeRequest(string, tgbotapi.Params) (tgbotapi.APIResponse, error) {
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (c *fakeClient) UploadFile(string, tgbotapi.Params, string, interface{}) (tgbotapi.APIResponse, error) {
+	return tgbotapi.APIResponse{Ok: true}, nil
+}
+
+// takeSent returns everything sent since the previous call except alerts.
+func (c *fakeClient) takeSent() []tgbotapi.Chattable {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	sent := make([]tgbotapi.Chattable, 0, len(c.sent))
+	for _, tgChattable := range c.sent {
+		if tgMessage, ok := tgChattable.(tgbotapi.MessageConfig); ok && tgMessage.ChatID == AlertsChatID {
+			continue
+		}
+		sent = append(sent, tgChattable)
+	}
+	c.sent = nil
+	return sent
+}
+
+// takeAlerts returns texts of alerts sent since the previous call of takeSent or
+// takeAlerts, dropping everything else.
+func (c *fakeClient) takeAlerts() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	alerts := make([]string, 0)
+	for _, tgChattable := range c.sent {
+		if tgMessage, ok := tgChattable.(tgbotapi.MessageConfig); ok && tgMessage.ChatID == AlertsChatID {
+			alerts = append(alerts, tgMessage.Text)
+		}
+	}
+	c.sent = nil
+	return alerts
+}
@@ -0,0 +1,131 @@
+package telegram
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+const (
+	// listenerStuckTimeout is how long a listener may handle one update. It
+	// covers the worst case of one send in the scheduler, which honors
+	// retry_after up to maxRetryAfter for every attempt, plus a minute for the
+	// rest of the handler, so a flood wait doesn't fail liveness.
+	listenerStuckTimeout = maxSendAttempts*maxRetryAfter + time.Minute
+	// updatesStaleTimeout is how long polling may go without a successful
+	// getUpdates, which returns at least once per timeoutSeconds.
+	updatesStaleTimeout = 3 * timeoutSeconds * time.Second
+
+	statusOK     = "ok"
+	statusFailed = "failed"
+)
+
+type healthStatus struct {
+	Status    string          `json:"status"`
+	Problems  []string        `json:"problems,omitempty"`
+	Database  databaseStatus  `json:"database"`
+	Updates   updatesStatus   `json:"updates"`
+	Listeners listenersStatus `json:"listeners"`
+	Store     storeStatus     `json:"store"`
+}
+
+type databaseStatus struct {
+	LoadedAt        time.Time `json:"loadedAt"`
+	LastReloadError string    `json:"lastReloadError,omitempty"`
+	Subjects        int       `json:"subjects"`
+	Tasks           int       `json:"tasks"`
+}
+
+type updatesStatus struct {
+	Mode           string     `json:"mode"`
+	LastReceivedAt *time.Time `json:"lastReceivedAt"`
+}
+
+type storeStatus struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// handleLiveness fails only if the bot can't recover without restart.
+func (b *Bot) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	status := b.getHealthStatus(time.Now())
+	problems := make([]string, 0)
+	if status.Listeners.Stuck > 0 {
+		problems = append(problems, fmt.Sprintf("%d listeners are stuck", status.Listeners.Stuck))
+	}
+	writeHealthStatus(w, status, problems)
+}
+
+// handleReadiness fails if the bot can't serve users now.
+func (b *Bot) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	status := b.getHealthStatus(now)
+	problems := make([]string, 0)
+	if status.Database.Tasks == 0 {
+		problems = append(problems, "database has no tasks")
+	}
+	if status.Updates.LastReceivedAt == nil {
+		problems = append(problems, "updates are not received yet")
+	} else if b.webhook == nil && now.Sub(*status.Updates.LastReceivedAt) > updatesStaleTimeout {
+		problems = append(problems, fmt.Sprintf("no successful getUpdates for %s", updatesStaleTimeout))
+	}
+	if status.Listeners.Stuck > 0 {
+		problems = append(problems, fmt.Sprintf("%d listeners are stuck", status.Listeners.Stuck))
+	}
+	if !status.Store.OK {
+		problems = append(problems, "state store is not available")
+	}
+	writeHealthStatus(w, status, problems)
+}
+
+func (b *Bot) getHealthStatus(now time.Time) *healthStatus {
+	database := b.database()
+	loaderStatus := b.loader.Status()
+	status := &healthStatus{
+		Database: databaseStatus{
+			LoadedAt: loaderStatus.LoadedAt,
+			Subjects: len(database.SubjectIDs),
+			Tasks:    len(database.Tasks),
+		},
+		Updates: updatesStatus{Mode: "polling"},
+		Store:   storeStatus{OK: true},
+	}
+	if loaderStatus.LastError != nil {
+		status.Database.LastReloadError = loaderStatus.LastError.Error()
+	}
+	if b.webhook != nil {
+		status.Updates.Mode = "webhook"
+	}
+	if lastUpdatesAt := atomic.LoadInt64(&b.receiving.lastUpdatesAt); lastUpdatesAt != 0 {
+		lastReceivedAt := time.Unix(0, lastUpdatesAt)
+		status.Updates.LastReceivedAt = &lastReceivedAt
+	}
+	status.Listeners = b.dispatcher.status(now, listenerStuckTimeout)
+	if err := b.store.Ping(); err != nil {
+		status.Store = storeStatus{OK: false, Error: err.Error()}
+	}
+	return status
+}
+
+func (b *Bot) markUpdatesReceived() {
+	atomic.StoreInt64(&b.receiving.lastUpdatesAt, time.Now().UnixNano())
+}
+
+func writeHealthStatus(w http.ResponseWriter, status *healthStatus, problems []string) {
+	status.Status = statusOK
+	code := http.StatusOK
+	if len(problems) > 0 {
+		status.Status = statusFailed
+		status.Problems = problems
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		logging.Warn("Error on writing health status", logging.Err(err))
+	}
+}
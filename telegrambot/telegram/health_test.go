@@ -0,0 +1,80 @@
+package telegram
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+func TestReadiness(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	status := checkHealth(t, b.handleReadiness, http.StatusServiceUnavailable)
+	if status.Database.Subjects != 1 || status.Database.Tasks != 2 || status.Database.LoadedAt.IsZero() {
+		t.Errorf("unexpected database status %+v", status.Database)
+	}
+	if status.Listeners.Count != listenersPoolSize || !status.Store.OK {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	b.markUpdatesReceived()
+	checkHealth(t, b.handleReadiness, http.StatusOK)
+
+	atomic.StoreInt64(&b.receiving.lastUpdatesAt, time.Now().Add(-updatesStaleTimeout-time.Minute).UnixNano())
+	checkHealth(t, b.handleReadiness, http.StatusServiceUnavailable)
+}
+
+func TestReadinessWithClosedStore(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	store, err := state.NewFileStore(filepath.Join(b.dir, "users.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Bot.store = store
+	b.markUpdatesReceived()
+	checkHealth(t, b.handleReadiness, http.StatusOK)
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	status := checkHealth(t, b.handleReadiness, http.StatusServiceUnavailable)
+	if status.Store.OK || status.Store.Error == "" {
+		t.Errorf("expected store error, got %+v", status.Store)
+	}
+}
+
+func TestLiveness(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	checkHealth(t, b.handleLiveness, http.StatusOK)
+
+	atomic.StoreInt64(&b.dispatcher.busySince[0], time.Now().Add(-listenerStuckTimeout-time.Second).UnixNano())
+	status := checkHealth(t, b.handleLiveness, http.StatusServiceUnavailable)
+	if status.Listeners.Busy != 1 || status.Listeners.Stuck != 1 {
+		t.Errorf("expected one stuck listener, got %+v", status.Listeners)
+	}
+}
+
+func checkHealth(t *testing.T, handler http.HandlerFunc, expectedCode int) *healthStatus {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	var status healthStatus
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("invalid health status %q: %v", recorder.Body.String(), err)
+	}
+	if recorder.Code != expectedCode {
+		t.Errorf("expected code %d, got %d with %+v", expectedCode, recorder.Code, status)
+	}
+	return &status
+}
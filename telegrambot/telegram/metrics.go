@@ -0,0 +1,219 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/metrics"
+)
+
+const (
+	updateTypeMessage       = "message"
+	updateTypeCallbackQuery = "callback_query"
+	updateTypePollAnswer    = "poll_answer"
+	updateTypeOther         = "other"
+
+	answerCorrect   = "correct"
+	answerIncorrect = "incorrect"
+
+	// errorCodeNetwork is the code label of errors without a Bot API response.
+	errorCodeNetwork = "network"
+)
+
+// activeUsersWindows are periods to count users who sent any update within.
+var activeUsersWindows = []time.Duration{time.Hour, 24 * time.Hour}
+
+type botMetrics struct {
+	registry        *metrics.Registry
+	updates         *metrics.CounterVec
+	handlerDuration *metrics.HistogramVec
+	tasksSent       *metrics.CounterVec
+	answers         *metrics.CounterVec
+	apiErrors       *metrics.CounterVec
+	activeUsers     *activeUsers
+}
+
+func newBotMetrics() *botMetrics {
+	registry := metrics.NewRegistry()
+	m := &botMetrics{
+		registry:        registry,
+		updates:         registry.NewCounterVec("usebot_updates_total", "Updates received by type.", "type"),
+		handlerDuration: registry.NewHistogramVec("usebot_handler_duration_seconds", "Time of handling one update by type.", metrics.DefaultBuckets, "type"),
+		tasksSent:       registry.NewCounterVec("usebot_tasks_sent_total", "Tasks sent to users by subject and level.", "subject", "level"),
+		answers:         registry.NewCounterVec("usebot_answers_total", "Answers to tasks by result.", "result"),
+		apiErrors:       registry.NewCounterVec("usebot_telegram_api_errors_total", "Failed Bot API requests by method and error code.", "method", "code"),
+		activeUsers:     newActiveUsers(),
+	}
+	registry.NewGaugeFunc("usebot_active_users", "Users who sent any update within the window.", func() map[string]float64 {
+		return m.activeUsers.count(time.Now())
+	}, "window")
+	return m
+}
+
+func (m *botMetrics) observeUpdate(update tgbotapi.Update, duration time.Duration) {
+	updateType := getUpdateType(update)
+	m.updates.Inc(updateType)
+	m.handlerDuration.Observe(duration.Seconds(), updateType)
+}
+
+func (m *botMetrics) observeTaskSent(task *collection.Task) {
+	m.tasksSent.Inc(task.SubjectID, task.Level.String())
+}
+
+func (m *botMetrics) observeAnswer(correct bool) {
+	if correct {
+		m.answers.Inc(answerCorrect)
+	} else {
+		m.answers.Inc(answerIncorrect)
+	}
+}
+
+func (m *botMetrics) observeAPIError(method string, err error) {
+	code := errorCodeNetwork
+	if tgError, ok := err.(tgbotapi.Error); ok {
+		code = strconv.Itoa(tgError.Code)
+	}
+	m.apiErrors.Inc(method, code)
+}
+
+func getUpdateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		return updateTypeMessage
+	case update.CallbackQuery != nil:
+		return updateTypeCallbackQuery
+	case update.PollAnswer != nil:
+		return updateTypePollAnswer
+	default:
+		return updateTypeOther
+	}
+}
+
+func getUpdateUserID(update tgbotapi.Update) (int, bool) {
+	switch {
+	case update.Message != nil && update.Message.From != nil:
+		return update.Message.From.ID, true
+	case update.CallbackQuery != nil && update.CallbackQuery.From != nil:
+		return update.CallbackQuery.From.ID, true
+	case update.PollAnswer != nil:
+		return update.PollAnswer.User.ID, true
+	default:
+		return 0, false
+	}
+}
+
+// activeUsers keeps the last update time of users seen within the longest window.
+type activeUsers struct {
+	mutex    sync.Mutex
+	lastSeen map[int]time.Time
+}
+
+func newActiveUsers() *activeUsers {
+	return &activeUsers{lastSeen: make(map[int]time.Time)}
+}
+
+func (a *activeUsers) mark(userID int, now time.Time) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.lastSeen[userID] = now
+}
+
+// count returns numbers of active users by window and forgets users who are
+// not active in any window.
+func (a *activeUsers) count(now time.Time) map[string]float64 {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	counts := make(map[string]float64, len(activeUsersWindows))
+	for _, window := range activeUsersWindows {
+		counts[formatWindow(window)] = 0
+	}
+	longestWindow := activeUsersWindows[len(activeUsersWindows)-1]
+	for userID, lastSeen := range a.lastSeen {
+		inactivity := now.Sub(lastSeen)
+		if inactivity > longestWindow {
+			delete(a.lastSeen, userID)
+			continue
+		}
+		for _, window := range activeUsersWindows {
+			if inactivity <= window {
+				counts[formatWindow(window)]++
+			}
+		}
+	}
+	return counts
+}
+
+func formatWindow(window time.Duration) string {
+	return fmt.Sprintf("%dh", int(window.Hours()))
+}
+
+// meteredClient counts failed requests of the wrapped client.
+type meteredClient struct {
+	telegramClient
+	metrics *botMetrics
+}
+
+func (c meteredClient) Send(tgChattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	tgMessage, err := c.telegramClient.Send(tgChattable)
+	if err != nil {
+		c.metrics.observeAPIError(getChattableMethod(tgChattable), err)
+	}
+	return tgMessage, err
+}
+
+func (c meteredClient) Request(tgChattable tgbotapi.Chattable) (tgbotapi.APIResponse, error) {
+	response, err := c.telegramClient.Request(tgChattable)
+	if err != nil {
+		c.metrics.observeAPIError(getChattableMethod(tgChattable), err)
+	}
+	return response, err
+}
+
+func (c meteredClient) GetUpdates(config tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	updates, err := c.telegramClient.GetUpdates(config)
+	if err != nil {
+		c.metrics.observeAPIError("getUpdates", err)
+	}
+	return updates, err
+}
+
+func (c meteredClient) MakeRequest(endpoint string, params tgbotapi.Params) (tgbotapi.APIResponse, error) {
+	response, err := c.telegramClient.MakeRequest(endpoint, params)
+	if err != nil {
+		c.metrics.observeAPIError(endpoint, err)
+	}
+	return response, err
+}
+
+func (c meteredClient) UploadFile(endpoint string, params tgbotapi.Params, fieldName string, file interface{}) (tgbotapi.APIResponse, error) {
+	response, err := c.telegramClient.UploadFile(endpoint, params, fieldName, file)
+	if err != nil {
+		c.metrics.observeAPIError(endpoint, err)
+	}
+	return response, err
+}
+
+// getChattableMethod returns the Bot API method of configs sent by the bot.
+// Chattable hides its method, so unknown configs are named by their type.
+func getChattableMethod(tgChattable tgbotapi.Chattable) string {
+	switch tgChattable.(type) {
+	case tgbotapi.MessageConfig, *tgbotapi.MessageConfig:
+		return "sendMessage"
+	case tgbotapi.SendPollConfig, *tgbotapi.SendPollConfig:
+		return "sendPoll"
+	case tgbotapi.EditMessageTextConfig, *tgbotapi.EditMessageTextConfig:
+		return "editMessageText"
+	case tgbotapi.EditMessageReplyMarkupConfig, *tgbotapi.EditMessageReplyMarkupConfig:
+		return "editMessageReplyMarkup"
+	case tgbotapi.CallbackConfig, *tgbotapi.CallbackConfig:
+		return "answerCallbackQuery"
+	default:
+		return strings.TrimPrefix(fmt.Sprintf("%T", tgChattable), "*")
+	}
+}
@@ -0,0 +1,112 @@
+package telegram
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+func TestMetrics(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	b.updateUser(testUserID, func(user *state.User) {
+		user.ChatID = testChatID
+		user.SelectedSubjectID = testSubjectID
+		user.SelectedLevel = collection.LevelMedium.String()
+	})
+	b.sendText(commandNext)
+	poll, err := b.store.LoadPoll("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.answerPoll(poll.ID, poll.CorrectOptionID+1)
+
+	if value := b.metrics.updates.Value(updateTypeMessage); value != 1 {
+		t.Errorf("expected 1 message update, got %v", value)
+	}
+	if value := b.metrics.updates.Value(updateTypePollAnswer); value != 1 {
+		t.Errorf("expected 1 poll answer update, got %v", value)
+	}
+	if value := b.metrics.tasksSent.Value(testSubjectID, collection.LevelMedium.String()); value != 2 {
+		t.Errorf("expected 2 tasks sent, got %v", value)
+	}
+	if value := b.metrics.answers.Value(answerIncorrect); value != 1 {
+		t.Errorf("expected 1 incorrect answer, got %v", value)
+	}
+
+	recorder := httptest.NewRecorder()
+	b.metrics.registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		`usebot_updates_total{type="message"} 1`,
+		`usebot_handler_duration_seconds_count{type="poll_answer"} 1`,
+		`usebot_answers_total{result="incorrect"} 1`,
+		`usebot_active_users{window="1h"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestMeteredClient(t *testing.T) {
+	m := newBotMetrics()
+	client := meteredClient{telegramClient: failingClient{newFakeClient()}, metrics: m}
+
+	_, _ = client.Send(tgbotapi.NewMessage(testChatID, "text"))
+	_, _ = client.Request(tgbotapi.NewCallback("callback", ""))
+	_, _ = client.GetUpdates(tgbotapi.NewUpdate(0))
+
+	if value := m.apiErrors.Value("sendMessage", "403"); value != 1 {
+		t.Errorf("expected 1 sendMessage error, got %v", value)
+	}
+	if value := m.apiErrors.Value("answerCallbackQuery", "403"); value != 1 {
+		t.Errorf("expected 1 answerCallbackQuery error, got %v", value)
+	}
+	if value := m.apiErrors.Value("getUpdates", errorCodeNetwork); value != 1 {
+		t.Errorf("expected 1 getUpdates network error, got %v", value)
+	}
+}
+
+func TestActiveUsers(t *testing.T) {
+	now := time.Now()
+	users := newActiveUsers()
+	users.mark(1, now.Add(-time.Minute))
+	users.mark(2, now.Add(-2*time.Hour))
+	users.mark(3, now.Add(-25*time.Hour))
+
+	counts := users.count(now)
+	if counts["1h"] != 1 || counts["24h"] != 2 {
+		t.Errorf("unexpected active users %v", counts)
+	}
+	if len(users.lastSeen) != 2 {
+		t.Errorf("expected inactive user to be forgotten, got %v", users.lastSeen)
+	}
+}
+
+// failingClient fails every request, with a Bot API error for chattables and
+// with a network error otherwise.
+type failingClient struct {
+	*fakeClient
+}
+
+func (failingClient) Send(tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return tgbotapi.Message{}, tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}
+}
+
+func (failingClient) Request(tgbotapi.Chattable) (tgbotapi.APIResponse, error) {
+	return tgbotapi.APIResponse{}, tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}
+}
+
+func (failingClient) GetUpdates(tgbotapi.UpdateConfig) ([]tgbotapi.Update, error) {
+	return nil, errors.New("connection refused")
+}
@@ -0,0 +1,222 @@
+package telegram
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/logging"
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+const (
+	// maxSendTaskAttempts is how many different tasks are tried for one request
+	// of the next task.
+	maxSendTaskAttempts = 3
+	// quarantineFailuresCount is how many times in a row a task may be rejected by
+	// Telegram before it is quarantined.
+	quarantineFailuresCount = 3
+
+	commandQuarantine   = "quarantine"
+	commandUnquarantine = "unquarantine"
+	argumentAll         = "all"
+
+	textTaskNotSent = "Не удалось отправить задание, попробуй ещё раз позже"
+)
+
+// quarantine keeps IDs of quarantined tasks in memory to filter tasks quickly.
+// Failures themselves are kept in the store. Tasks whose polls are rejected are
+// remembered until restart to send them as messages right away.
+type quarantine struct {
+	mutex           sync.RWMutex
+	taskIDs         map[int]struct{}
+	rejectedPollIDs map[int]struct{}
+}
+
+// pendingAlert is collected under the quarantine lock and sent after it is
+// released, so readers of the quarantine never wait for alerts.
+type pendingAlert struct {
+	message string
+	fields  []logging.Field
+}
+
+func newQuarantine(store state.Store) *quarantine {
+	q := &quarantine{taskIDs: make(map[int]struct{}), rejectedPollIDs: make(map[int]struct{})}
+	failures, err := store.LoadTaskFailures()
+	if err != nil {
+		logging.Error("Error on loading task failures", logging.Err(err))
+	}
+	for _, failure := range failures {
+		if failure.Quarantined {
+			q.taskIDs[failure.TaskID] = struct{}{}
+		}
+	}
+	return q
+}
+
+func (q *quarantine) contains(taskID int) bool {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	_, found := q.taskIDs[taskID]
+	return found
+}
+
+func (q *quarantine) isPollRejected(taskID int) bool {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	_, found := q.rejectedPollIDs[taskID]
+	return found
+}
+
+func (q *quarantine) rejectPoll(taskID int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.rejectedPollIDs[taskID] = struct{}{}
+}
+
+func (q *quarantine) isEmpty() bool {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	return len(q.taskIDs) == 0
+}
+
+// isTaskError reports whether Telegram rejected the request because of its
+// content, so another task may still be sent successfully.
+func isTaskError(err error) bool {
+	tgError, ok := err.(tgbotapi.Error)
+	return ok && tgError.Code == http.StatusBadRequest
+}
+
+// availableTasks returns the tasks which are not quarantined.
+func (b *Bot) availableTasks(tasks []*collection.Task) []*collection.Task {
+	if b.quarantine.isEmpty() {
+		return tasks
+	}
+	available := make([]*collection.Task, 0, len(tasks))
+	for _, task := range tasks {
+		if !b.quarantine.contains(task.ID) {
+			available = append(available, task)
+		}
+	}
+	return available
+}
+
+// recordTaskFailure counts the failure and quarantines the task if it fails
+// too many times in a row.
+func (b *Bot) recordTaskFailure(taskID int, err error) {
+	b.quarantine.mutex.Lock()
+	alert := b.saveTaskFailure(taskID, err)
+	b.quarantine.mutex.Unlock()
+	if alert != nil {
+		b.sendAlert(alert.message, alert.fields...)
+	}
+}
+
+// saveTaskFailure must be called with the quarantine locked.
+func (b *Bot) saveTaskFailure(taskID int, err error) *pendingAlert {
+	failure, loadErr := b.store.LoadTaskFailure(taskID)
+	if loadErr == state.ErrNotFound {
+		failure = &state.TaskFailure{TaskID: taskID}
+	} else if loadErr != nil {
+		return &pendingAlert{"Error on loading task failure", []logging.Field{logging.Int("taskId", taskID), logging.Err(loadErr)}}
+	}
+	failure.Count++
+	failure.LastError = err.Error()
+	failure.LastFailedAt = time.Now()
+	quarantined := false
+	if failure.Count >= quarantineFailuresCount && !failure.Quarantined {
+		failure.Quarantined = true
+		quarantined = true
+		b.quarantine.taskIDs[taskID] = struct{}{}
+	}
+	if saveErr := b.store.SaveTaskFailure(failure); saveErr != nil {
+		return &pendingAlert{"Error on saving task failure", []logging.Field{logging.Int("taskId", taskID), logging.Err(saveErr)}}
+	}
+	if quarantined {
+		return &pendingAlert{
+			fmt.Sprintf("Task is quarantined, clear it by /%s when it is fixed", commandUnquarantine),
+			[]logging.Field{logging.Int("taskId", taskID), logging.Int("failures", failure.Count), logging.Err(err)},
+		}
+	}
+	return nil
+}
+
+// clearTaskFailure resets failures of the task after it is sent successfully.
+func (b *Bot) clearTaskFailure(taskID int) {
+	if _, err := b.store.LoadTaskFailure(taskID); err != nil {
+		return
+	}
+	b.quarantine.mutex.Lock()
+	err := b.store.DeleteTaskFailure(taskID)
+	b.quarantine.mutex.Unlock()
+	if err != nil {
+		b.sendAlert("Error on deleting task failure", logging.Int("taskId", taskID), logging.Err(err))
+	}
+}
+
+// getQuarantineList lists quarantined tasks with their last errors.
+func (b *Bot) getQuarantineList(chatID int64) tgbotapi.Chattable {
+	failures, err := b.store.LoadTaskFailures()
+	if err != nil {
+		b.sendAlert("Error on loading task failures", logging.Err(err))
+	}
+	lines := make([]string, 0, len(failures)+1)
+	for _, failure := range failures {
+		if failure.Quarantined {
+			lines = append(lines, fmt.Sprintf("%d: %d failures, last at %s: %s",
+				failure.TaskID, failure.Count, failure.LastFailedAt.Format(time.RFC3339), failure.LastError))
+		}
+	}
+	if len(lines) == 0 {
+		lines = append(lines, "No quarantined tasks")
+	} else {
+		lines = append([]string{fmt.Sprintf("Quarantined tasks, clear by /%s <id|%s>:", commandUnquarantine, argumentAll)}, lines...)
+	}
+	tgMessage := tgbotapi.NewMessage(chatID, truncateText(strings.Join(lines, "\n"), botapi.MaxMessageLength))
+	return &tgMessage
+}
+
+// unquarantine clears failures of the task or of all tasks, so they are sent again.
+func (b *Bot) unquarantine(chatID int64, argument string) {
+	var taskIDs []int
+	if argument == argumentAll {
+		failures, err := b.store.LoadTaskFailures()
+		if err != nil {
+			b.sendAlert("Error on loading task failures", logging.Err(err))
+			return
+		}
+		for _, failure := range failures {
+			taskIDs = append(taskIDs, failure.TaskID)
+		}
+	} else {
+		taskID, err := strconv.Atoi(argument)
+		if err != nil {
+			b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, fmt.Sprintf("Usage: /%s <id|%s>", commandUnquarantine, argumentAll)))
+			return
+		}
+		taskIDs = append(taskIDs, taskID)
+	}
+
+	alerts := make([]pendingAlert, 0)
+	b.quarantine.mutex.Lock()
+	for _, taskID := range taskIDs {
+		if err := b.store.DeleteTaskFailure(taskID); err != nil {
+			alerts = append(alerts, pendingAlert{"Error on deleting task failure", []logging.Field{logging.Int("taskId", taskID), logging.Err(err)}})
+			continue
+		}
+		delete(b.quarantine.taskIDs, taskID)
+		delete(b.quarantine.rejectedPollIDs, taskID)
+	}
+	b.quarantine.mutex.Unlock()
+	for _, alert := range alerts {
+		b.sendAlert(alert.message, alert.fields...)
+	}
+	b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, fmt.Sprintf("Cleared failures of %d tasks", len(taskIDs))))
+}
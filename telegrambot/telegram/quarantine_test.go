@@ -0,0 +1,157 @@
+package telegram
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+var errBadRequest = tgbotapi.Error{Code: 400, Message: "Bad Request: message is too long"}
+
+func rejectPolls(tgChattable tgbotapi.Chattable) error {
+	if _, ok := tgChattable.(*tgbotapi.SendPollConfig); ok {
+		return errBadRequest
+	}
+	return nil
+}
+
+func rejectTasks(tgChattable tgbotapi.Chattable) error {
+	if tgMessage, ok := tgChattable.(*tgbotapi.MessageConfig); ok && tgMessage.ParseMode == tgbotapi.ModeHTML {
+		return errBadRequest
+	}
+	return rejectPolls(tgChattable)
+}
+
+func (b *testBot) selectSubjectAndLevel(level collection.Level) {
+	b.updateUser(testUserID, func(user *state.User) {
+		user.ChatID = testChatID
+		user.SelectedSubjectID = testSubjectID
+		user.SelectedLevel = level.String()
+	})
+}
+
+func TestPollFallbackToMessage(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	pollsCount := 0
+	b.client.reject = func(tgChattable tgbotapi.Chattable) error {
+		if _, ok := tgChattable.(*tgbotapi.SendPollConfig); ok {
+			pollsCount++
+		}
+		return rejectPolls(tgChattable)
+	}
+	b.selectSubjectAndLevel(collection.LevelMedium)
+
+	for i := 0; i < 2; i++ {
+		findMessage(t, b.sendText(commandNext), "Земля круглая?")
+	}
+	if _, err := b.store.LoadTaskFailure(2); err != state.ErrNotFound {
+		t.Errorf("expected no failure when task is sent as message, got %v", err)
+	}
+	if pollsCount != 1 {
+		t.Errorf("expected rejected poll not to be sent again, got %d attempts", pollsCount)
+	}
+}
+
+func TestExamSkipsRejectedTask(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	b.client.reject = func(tgChattable tgbotapi.Chattable) error {
+		if tgMessage, ok := tgChattable.(*tgbotapi.MessageConfig); ok && strings.Contains(tgMessage.Text, "2 + 2") {
+			return errBadRequest
+		}
+		return nil
+	}
+	b.selectSubjectAndLevel(collection.LevelLow)
+
+	task := findMessage(t, b.sendText(commandExam), "Земля круглая?")
+	exam := b.loadUser(testUserID).Exam
+	if exam == nil || len(exam.TaskIDs) != 2 {
+		t.Fatalf("expected exam of both tasks, got %+v", exam)
+	}
+	if failure, err := b.store.LoadTaskFailure(1); err != nil || failure.Count != 1 {
+		t.Errorf("expected failure of the rejected task to be recorded, got %+v, %v", failure, err)
+	}
+
+	tgKeyboard := task.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	report := findMessage(t, b.pressData(task, *tgKeyboard.InlineKeyboard[0][0].CallbackData), fmt.Sprintf(textExamReport, testSubjectName))
+	if !strings.Contains(report.Text, fmt.Sprintf(textExamCorrect, 0, 1)) && !strings.Contains(report.Text, fmt.Sprintf(textExamCorrect, 1, 1)) {
+		t.Errorf("expected skipped task not to be scored, got %q", report.Text)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	b.client.reject = rejectTasks
+	b.selectSubjectAndLevel(collection.LevelLow)
+
+	for i := 0; i < 4; i++ {
+		sent := b.sendText(commandNext)
+		if len(sent) != 1 || sent[0].(tgbotapi.MessageConfig).Text != textTaskNotSent {
+			t.Fatalf("expected apology after failed attempts, got %v", sent)
+		}
+	}
+	failures, err := b.store.LoadTaskFailures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failures) != 2 || !failures[0].Quarantined || !failures[1].Quarantined {
+		t.Fatalf("expected both tasks to be quarantined, got %+v", failures)
+	}
+	if !b.quarantine.contains(1) || !b.quarantine.contains(2) {
+		t.Fatal("expected quarantined tasks to be skipped")
+	}
+
+	list := b.sendAdminCommand("/" + commandQuarantine)
+	if !strings.Contains(list, "1: ") || !strings.Contains(list, "2: ") {
+		t.Errorf("expected both tasks in quarantine list, got %q", list)
+	}
+	b.sendAdminCommand("/" + commandUnquarantine + " 1")
+	if b.quarantine.contains(1) || !b.quarantine.contains(2) {
+		t.Fatal("expected only task 1 to be cleared")
+	}
+
+	b.client.reject = nil
+	findMessage(t, b.sendText(commandNext), "Сколько будет 2 + 2?")
+	b.sendAdminCommand("/" + commandUnquarantine + " " + argumentAll)
+	if failures, _ := b.store.LoadTaskFailures(); len(failures) != 0 || !b.quarantine.isEmpty() {
+		t.Errorf("expected all failures to be cleared, got %+v", failures)
+	}
+}
+
+// sendAdminCommand sends the command from the user to the bot and returns the reply.
+func (b *testBot) sendAdminCommand(text string) string {
+	return b.sendCommandFrom(testAdminID, text)
+}
+
+func (b *testBot) sendCommandFrom(userID int, text string) string {
+	command := strings.SplitN(text, " ", 2)[0]
+	b.handleUpdate(tgbotapi.Update{Message: &tgbotapi.Message{
+		From:     &tgbotapi.User{ID: userID},
+		Chat:     &tgbotapi.Chat{ID: int64(userID)},
+		Text:     text,
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}})
+	b.client.mutex.Lock()
+	defer b.client.mutex.Unlock()
+	var reply string
+	for _, tgChattable := range b.client.sent {
+		tgMessage, ok := tgChattable.(*tgbotapi.MessageConfig)
+		if !ok {
+			if value, isValue := tgChattable.(tgbotapi.MessageConfig); isValue {
+				tgMessage, ok = &value, true
+			}
+		}
+		if ok && tgMessage.ChatID == int64(userID) {
+			reply = tgMessage.Text
+		}
+	}
+	b.client.sent = nil
+	return reply
+}
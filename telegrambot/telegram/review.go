@@ -0,0 +1,91 @@
+package telegram
+
+import (
+	"fmt"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/logging"
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+const (
+	// An unanswered review task is not offered again for this long.
+	reviewPostponePeriod = 10 * time.Minute
+
+	textReviewEmpty   = "Ошибок для повторения нет. Так держать!"
+	textReviewNotDue  = "Сейчас повторять нечего. Заданий с ошибками ждёт повторения: %d."
+	textReviewStarted = "Повторим задание, в котором была допущена ошибка."
+)
+
+func (b *Bot) scheduleReview(userID, taskID int, correct bool) {
+	review, err := b.store.LoadReview(userID, taskID)
+	if err == state.ErrNotFound {
+		review, err = nil, nil
+	} else if err != nil {
+		b.sendAlert("Error on loading review", logging.Int("taskId", taskID), logging.UserID(userID), logging.Err(err))
+		return
+	}
+
+	nextReview := state.ScheduleReview(review, userID, taskID, correct, time.Now())
+	if nextReview != nil {
+		err = b.store.SaveReview(nextReview)
+	} else if review != nil {
+		err = b.store.DeleteReview(userID, taskID)
+	}
+	if err != nil {
+		b.sendAlert("Error on scheduling review", logging.Int("taskId", taskID), logging.UserID(userID), logging.Err(err))
+	}
+}
+
+// popDueReviewTask returns the most overdue task of the subject or of any subject
+// if the subject ID is empty, and postpones its review until it is answered.
+func (b *Bot) popDueReviewTask(userID int, subjectID string) *collection.Task {
+	reviews, err := b.store.LoadReviews(userID)
+	if err != nil {
+		b.sendAlert("Error on loading reviews", logging.UserID(userID), logging.Err(err))
+		return nil
+	}
+	now := time.Now()
+	for i := range reviews {
+		review := &reviews[i]
+		if !review.IsDue(now) {
+			break
+		}
+		task, found := b.database().GetTask(review.TaskID)
+		if !found || (subjectID != "" && task.SubjectID != subjectID) || b.quarantine.contains(task.ID) {
+			continue
+		}
+		review.DueAt = now.Add(reviewPostponePeriod)
+		if err := b.store.SaveReview(review); err != nil {
+			b.sendAlert("Error on postponing review", logging.Int("taskId", review.TaskID), logging.UserID(userID), logging.Err(err))
+		}
+		return task
+	}
+	return nil
+}
+
+func (b *Bot) sendReviewTask(chatID int64, userID int) {
+	task := b.popDueReviewTask(userID, "")
+	if task != nil {
+		b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, textReviewStarted))
+		if err := b.sendTask(chatID, userID, task); err != nil {
+			b.sendWithAlertOnError(tgbotapi.NewMessage(chatID, textTaskNotSent))
+		}
+		return
+	}
+
+	reviews, err := b.store.LoadReviews(userID)
+	if err != nil {
+		b.sendAlert("Error on loading reviews", logging.UserID(userID), logging.Err(err))
+	}
+	var tgMessage tgbotapi.MessageConfig
+	if len(reviews) == 0 {
+		tgMessage = tgbotapi.NewMessage(chatID, textReviewEmpty)
+	} else {
+		tgMessage = tgbotapi.NewMessage(chatID, fmt.Sprintf(textReviewNotDue, len(reviews)))
+	}
+	b.sendWithAlertOnError(tgMessage)
+}
@@ -0,0 +1,23 @@
+package telegram
+
+import (
+	"testing"
+)
+
+func TestScheduleReviewOfNotScheduledTask(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	b.scheduleReview(testUserID, 1, true)
+	if alerts := b.client.takeAlerts(); len(alerts) != 0 {
+		t.Errorf("expected correct answer of a task not scheduled for review to pass silently, got %q", alerts)
+	}
+	b.scheduleReview(testUserID, 1, false)
+	b.scheduleReview(testUserID, 1, true)
+	if _, err := b.store.LoadReview(testUserID, 1); err != nil {
+		t.Errorf("expected review to move to the next box, got %v", err)
+	}
+	if alerts := b.client.takeAlerts(); len(alerts) != 0 {
+		t.Errorf("expected no alerts, got %q", alerts)
+	}
+}
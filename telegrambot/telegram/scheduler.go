@@ -0,0 +1,357 @@
+package telegram
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+// sendPriority orders requests waiting for rate limits, lower goes first.
+type sendPriority int
+
+const (
+	// priorityReply is for replies to users, who are waiting for them.
+	priorityReply sendPriority = iota
+	// priorityAlert is for alerts to admins.
+	priorityAlert
+	// priorityBackground is for messages not triggered by users, like broadcasts
+	// or reports of expired exams.
+	priorityBackground
+
+	prioritiesCount = 3
+)
+
+const (
+	// maxSendAttempts is how many times a request rejected with 429 is sent.
+	maxSendAttempts = 3
+	// maxRetryAfter is the longest retry_after the sender waits for, longer ones
+	// are returned as errors to not keep handlers busy.
+	maxRetryAfter = 30 * time.Second
+	// maxIdleChatBuckets is how many chat buckets are kept before full ones are
+	// dropped.
+	maxIdleChatBuckets = 1000
+)
+
+// sendLimits are rates of requests allowed by Telegram, see
+// https://core.telegram.org/bots/faq#my-bot-is-hitting-limits-how-do-i-avoid-this
+type sendLimits struct {
+	global bucketLimit
+	chat   bucketLimit
+	group  bucketLimit
+}
+
+type bucketLimit struct {
+	// rate is the number of requests per second.
+	rate float64
+	// burst is the number of requests which may be sent at once.
+	burst float64
+}
+
+var defaultSendLimits = sendLimits{
+	global: bucketLimit{rate: 30, burst: 30},
+	chat:   bucketLimit{rate: 1, burst: 3},
+	group:  bucketLimit{rate: 20.0 / 60, burst: 3},
+}
+
+type tokenBucket struct {
+	limit       bucketLimit
+	tokens      float64
+	updatedAt   time.Time
+	pausedUntil time.Time
+}
+
+func newTokenBucket(limit bucketLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: limit.burst, updatedAt: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if now.After(b.updatedAt) {
+		b.tokens += now.Sub(b.updatedAt).Seconds() * b.limit.rate
+		if b.tokens > b.limit.burst {
+			b.tokens = b.limit.burst
+		}
+		b.updatedAt = now
+	}
+}
+
+// wait returns how long to wait for a token, zero if it is available.
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	if now.Before(b.pausedUntil) {
+		return b.pausedUntil.Sub(now)
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.limit.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+func (b *tokenBucket) isFull(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.limit.burst && !now.Before(b.pausedUntil)
+}
+
+type sendRequest struct {
+	priority sendPriority
+	chatID   int64
+	send     func() error
+	attempts int
+	done     chan error
+}
+
+// sendScheduler sends requests to Bot API within rate limits: global, per
+// private chat and per group. Requests wait in queues by priority, a request
+// is skipped while its chat is limited so it doesn't hold up other chats.
+// Requests rejected with 429 are sent again after retry_after.
+type sendScheduler struct {
+	limits sendLimits
+	now    func() time.Time
+
+	mutex   sync.Mutex
+	queues  [prioritiesCount][]*sendRequest
+	global  *tokenBucket
+	chats   map[int64]*tokenBucket
+	running bool
+	wake    chan struct{}
+}
+
+func newSendScheduler(limits sendLimits) *sendScheduler {
+	now := time.Now()
+	return &sendScheduler{
+		limits: limits,
+		now:    time.Now,
+		global: newTokenBucket(limits.global, now),
+		chats:  make(map[int64]*tokenBucket),
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// do calls send when rate limits allow and returns its error. Chat ID is zero
+// for requests not limited per chat.
+func (s *sendScheduler) do(priority sendPriority, chatID int64, send func() error) error {
+	request := &sendRequest{priority: priority, chatID: chatID, send: send, done: make(chan error, 1)}
+	s.enqueue(request, false)
+	return <-request.done
+}
+
+func (s *sendScheduler) enqueue(request *sendRequest, first bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	queue := s.queues[request.priority]
+	if first {
+		s.queues[request.priority] = append([]*sendRequest{request}, queue...)
+	} else {
+		s.queues[request.priority] = append(queue, request)
+	}
+	if !s.running {
+		s.running = true
+		go s.run()
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// run sends requests while there are any in queues.
+func (s *sendScheduler) run() {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		request, wait, empty := s.next()
+		if empty {
+			return
+		}
+		if request != nil {
+			go s.execute(request)
+			continue
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-s.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// next takes a request which may be sent now. Otherwise it returns how long to
+// wait for one, or that queues are empty and the scheduler is stopped.
+func (s *sendScheduler) next() (*sendRequest, time.Duration, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	empty := true
+	minWait := time.Duration(-1)
+	for priority := range s.queues {
+		for i, request := range s.queues[priority] {
+			empty = false
+			wait := s.global.wait(now)
+			var chat *tokenBucket
+			if request.chatID != 0 {
+				chat = s.chatBucket(request.chatID, now)
+				if chatWait := chat.wait(now); chatWait > wait {
+					wait = chatWait
+				}
+			}
+			if wait == 0 {
+				s.global.take()
+				if chat != nil {
+					chat.take()
+				}
+				s.queues[priority] = append(s.queues[priority][:i:i], s.queues[priority][i+1:]...)
+				return request, 0, false
+			}
+			if minWait < 0 || wait < minWait {
+				minWait = wait
+			}
+		}
+	}
+	if empty {
+		s.running = false
+		s.pruneChatBuckets(now)
+	}
+	return nil, minWait, empty
+}
+
+func (s *sendScheduler) chatBucket(chatID int64, now time.Time) *tokenBucket {
+	bucket, found := s.chats[chatID]
+	if !found {
+		limit := s.limits.chat
+		if chatID < 0 {
+			limit = s.limits.group
+		}
+		bucket = newTokenBucket(limit, now)
+		s.chats[chatID] = bucket
+	}
+	return bucket
+}
+
+func (s *sendScheduler) pruneChatBuckets(now time.Time) {
+	if len(s.chats) <= maxIdleChatBuckets {
+		return
+	}
+	for chatID, bucket := range s.chats {
+		if bucket.isFull(now) {
+			delete(s.chats, chatID)
+		}
+	}
+}
+
+func (s *sendScheduler) execute(request *sendRequest) {
+	request.attempts++
+	err := request.send()
+	retryAfter := getRetryAfter(err)
+	if retryAfter <= 0 || retryAfter > maxRetryAfter || request.attempts >= maxSendAttempts {
+		request.done <- err
+		return
+	}
+	logging.Warn(
+		"Too many requests, retrying",
+		logging.ChatID(request.chatID),
+		logging.Duration("retryAfter", retryAfter),
+		logging.Int("attempt", request.attempts),
+	)
+	s.pause(request.chatID, retryAfter)
+	s.enqueue(request, true)
+}
+
+// pause stops sending to the chat, or any request if there is no chat, until
+// retry_after is over.
+func (s *sendScheduler) pause(chatID int64, retryAfter time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := s.now()
+	bucket := s.global
+	if chatID != 0 {
+		bucket = s.chatBucket(chatID, now)
+	}
+	if pausedUntil := now.Add(retryAfter); pausedUntil.After(bucket.pausedUntil) {
+		bucket.pausedUntil = pausedUntil
+	}
+}
+
+func getRetryAfter(err error) time.Duration {
+	tgError, ok := err.(tgbotapi.Error)
+	if !ok || tgError.Code != http.StatusTooManyRequests {
+		return 0
+	}
+	return time.Duration(tgError.RetryAfter) * time.Second
+}
+
+// scheduledClient sends chattables through the scheduler with its priority.
+// Other requests are not limited.
+type scheduledClient struct {
+	telegramClient
+	scheduler *sendScheduler
+	priority  sendPriority
+}
+
+func (c scheduledClient) Send(tgChattable tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var tgMessage tgbotapi.Message
+	err := c.scheduler.do(c.priority, getChattableChatID(tgChattable), func() error {
+		var err error
+		tgMessage, err = c.telegramClient.Send(tgChattable)
+		return err
+	})
+	return tgMessage, err
+}
+
+func (c scheduledClient) Request(tgChattable tgbotapi.Chattable) (tgbotapi.APIResponse, error) {
+	var response tgbotapi.APIResponse
+	err := c.scheduler.do(c.priority, getChattableChatID(tgChattable), func() error {
+		var err error
+		response, err = c.telegramClient.Request(tgChattable)
+		return err
+	})
+	return response, err
+}
+
+// client returns the API client which sends with the priority. Clients set by
+// tests are returned as they are.
+func (b *Bot) client(priority sendPriority) telegramClient {
+	if scheduled, ok := b.api.(scheduledClient); ok {
+		scheduled.priority = priority
+		return scheduled
+	}
+	return b.api
+}
+
+// getChattableChatID returns the chat of configs sent by the bot or zero for
+// requests which are not sent to a chat, like callback query answers.
+func getChattableChatID(tgChattable tgbotapi.Chattable) int64 {
+	switch config := tgChattable.(type) {
+	case tgbotapi.MessageConfig:
+		return config.ChatID
+	case *tgbotapi.MessageConfig:
+		return config.ChatID
+	case tgbotapi.SendPollConfig:
+		return config.ChatID
+	case *tgbotapi.SendPollConfig:
+		return config.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return config.ChatID
+	case *tgbotapi.EditMessageTextConfig:
+		return config.ChatID
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return config.ChatID
+	case *tgbotapi.EditMessageReplyMarkupConfig:
+		return config.ChatID
+	default:
+		return 0
+	}
+}
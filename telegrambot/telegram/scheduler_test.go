@@ -0,0 +1,132 @@
+package telegram
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var testSendLimits = sendLimits{
+	global: bucketLimit{rate: 100, burst: 100},
+	chat:   bucketLimit{rate: 5, burst: 1},
+	group:  bucketLimit{rate: 5, burst: 1},
+}
+
+type sendLog struct {
+	mutex sync.Mutex
+	names []string
+}
+
+func (l *sendLog) add(name string) func() error {
+	return func() error {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		l.names = append(l.names, name)
+		return nil
+	}
+}
+
+func (l *sendLog) get() []string {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return append([]string(nil), l.names...)
+}
+
+func (s *sendScheduler) waitForQueued(t *testing.T, count int) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		s.mutex.Lock()
+		queued := 0
+		for _, queue := range s.queues {
+			queued += len(queue)
+		}
+		s.mutex.Unlock()
+		if queued == count {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d queued requests", count)
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	limits := testSendLimits
+	limits.global = bucketLimit{rate: 20, burst: 1}
+	scheduler := newSendScheduler(limits)
+	scheduler.global.tokens = 0
+
+	var log sendLog
+	var wg sync.WaitGroup
+	wg.Add(3)
+	for i, request := range []struct {
+		name     string
+		priority sendPriority
+	}{{"background", priorityBackground}, {"alert", priorityAlert}, {"reply", priorityReply}} {
+		request := request
+		go func() {
+			defer wg.Done()
+			_ = scheduler.do(request.priority, 0, log.add(request.name))
+		}()
+		scheduler.waitForQueued(t, i+1)
+	}
+	wg.Wait()
+
+	names := log.get()
+	if len(names) != 3 || names[0] != "reply" || names[1] != "alert" || names[2] != "background" {
+		t.Errorf("expected requests to be sent by priority, got %v", names)
+	}
+}
+
+func TestSchedulerChatLimit(t *testing.T) {
+	scheduler := newSendScheduler(testSendLimits)
+	var log sendLog
+	if err := scheduler.do(priorityReply, 1, log.add("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	startedAt := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = scheduler.do(priorityReply, 1, log.add("second"))
+	}()
+	scheduler.waitForQueued(t, 1)
+	if err := scheduler.do(priorityReply, 2, log.add("other chat")); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+
+	if names := log.get(); len(names) != 3 || names[1] != "other chat" {
+		t.Errorf("expected limited chat not to hold up other chats, got %v", names)
+	}
+	if elapsed := time.Since(startedAt); elapsed < 150*time.Millisecond {
+		t.Errorf("expected second request to the chat to wait for a token, waited %s", elapsed)
+	}
+}
+
+func TestSchedulerRetryAfter(t *testing.T) {
+	scheduler := newSendScheduler(testSendLimits)
+	attempts := 0
+	startedAt := time.Now()
+	err := scheduler.do(priorityReply, 1, func() error {
+		attempts++
+		if attempts == 1 {
+			return tgbotapi.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}}
+		}
+		return nil
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("expected request to succeed on the second attempt, got %d attempts and %v", attempts, err)
+	}
+	if elapsed := time.Since(startedAt); elapsed < time.Second {
+		t.Errorf("expected retry after 1s, retried after %s", elapsed)
+	}
+
+	tooLong := tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 3600}}
+	if err := scheduler.do(priorityReply, 2, func() error { return tooLong }); err != tooLong {
+		t.Errorf("expected too long retry_after to be returned, got %v", err)
+	}
+}
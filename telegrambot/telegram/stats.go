@@ -0,0 +1,149 @@
+package telegram
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+const (
+	maxStatsThemes = 10
+
+	textStats        = "Твоя статистика"
+	textStatsEmpty   = "Пока нет ни одного ответа. Выбери предмет и реши несколько заданий."
+	textStatsSubject = "Предметы"
+	textStatsLevel   = "Сложность"
+	textStatsSection = "Разделы"
+	textStatsTheme   = "Темы"
+)
+
+type accuracy struct {
+	total   int
+	correct int
+}
+
+func (a *accuracy) add(correct bool) {
+	a.total++
+	if correct {
+		a.correct++
+	}
+}
+
+func (a *accuracy) String() string {
+	return fmt.Sprintf("%d из %d (%d%%)", a.correct, a.total, a.correct*100/a.total)
+}
+
+type userStats struct {
+	subjects map[string]*accuracy
+	levels   map[string]*accuracy
+	sections map[string]*accuracy
+	themes   map[string]*accuracy
+}
+
+func newUserStats() *userStats {
+	return &userStats{
+		subjects: make(map[string]*accuracy),
+		levels:   make(map[string]*accuracy),
+		sections: make(map[string]*accuracy),
+		themes:   make(map[string]*accuracy),
+	}
+}
+
+// add counts the answer in subject and level totals and, if the task belongs to
+// the subject selected by the user, in codifier section and theme totals too.
+func (s *userStats) add(task *collection.Task, correct bool, selectedSubject *collection.Subject) {
+	getAccuracy(s.subjects, task.SubjectID).add(correct)
+	getAccuracy(s.levels, task.Level.String()).add(correct)
+	if selectedSubject == nil || task.SubjectID != selectedSubject.ID {
+		return
+	}
+	sections := make(map[string]struct{})
+	for _, theme := range task.Themes {
+		getAccuracy(s.themes, theme).add(correct)
+		code, _ := collection.ParseThemeCode(theme)
+		if node, found := selectedSubject.GetCodifierNode(code); found && !node.IsRoot() {
+			sections[node.Section().Code] = struct{}{}
+		}
+	}
+	for code := range sections {
+		getAccuracy(s.sections, code).add(correct)
+	}
+}
+
+func getAccuracy(accuracies map[string]*accuracy, key string) *accuracy {
+	if _, found := accuracies[key]; !found {
+		accuracies[key] = &accuracy{}
+	}
+	return accuracies[key]
+}
+
+func (b *Bot) getStats(chatID int64, userID int) tgbotapi.Chattable {
+	answers, err := b.store.LoadAnswers(userID)
+	if err != nil {
+		b.sendAlert("Error on loading answers", logging.UserID(userID), logging.Err(err))
+	}
+	database := b.database()
+	selectedSubject, _ := getSelectedSubject(database, b.loadUser(userID))
+
+	stats := newUserStats()
+	for _, answer := range answers {
+		if task, found := database.GetTask(answer.TaskID); found {
+			stats.add(task, answer.Correct, selectedSubject)
+		}
+	}
+	if len(stats.subjects) == 0 {
+		tgMessage := tgbotapi.NewMessage(chatID, textStatsEmpty)
+		return &tgMessage
+	}
+
+	lines := []string{textStats, "", textStatsSubject + ":"}
+	for _, subjectID := range database.SubjectIDs {
+		if subjectAccuracy, found := stats.subjects[subjectID]; found {
+			lines = append(lines, fmt.Sprintf("%s: %s", database.Subjects[subjectID].Name, subjectAccuracy))
+		}
+	}
+
+	lines = append(lines, "", textStatsLevel+":")
+	for _, level := range []collection.Level{collection.LevelLow, collection.LevelMedium, collection.LevelHigh} {
+		if levelAccuracy, found := stats.levels[level.String()]; found {
+			lines = append(lines, fmt.Sprintf("%s: %s", level, levelAccuracy))
+		}
+	}
+
+	if len(stats.sections) > 0 {
+		lines = append(lines, "", fmt.Sprintf("%s (%s):", textStatsSection, selectedSubject.Name))
+		for _, section := range selectedSubject.Codifier.Children {
+			if sectionAccuracy, found := stats.sections[section.Code]; found {
+				lines = append(lines, fmt.Sprintf("%s: %s", formatPlainText(section.String()), sectionAccuracy))
+			}
+		}
+	}
+
+	if len(stats.themes) > 0 {
+		lines = append(lines, "", fmt.Sprintf("%s (%s):", textStatsTheme, selectedSubject.Name))
+		themes := make([]string, 0, len(stats.themes))
+		for theme := range stats.themes {
+			themes = append(themes, theme)
+		}
+		sort.Slice(themes, func(i, j int) bool {
+			if stats.themes[themes[i]].total != stats.themes[themes[j]].total {
+				return stats.themes[themes[i]].total > stats.themes[themes[j]].total
+			}
+			return themes[i] < themes[j]
+		})
+		if len(themes) > maxStatsThemes {
+			themes = themes[:maxStatsThemes]
+		}
+		for _, theme := range themes {
+			lines = append(lines, fmt.Sprintf("%s: %s", formatPlainText(theme), stats.themes[theme]))
+		}
+	}
+
+	tgMessage := tgbotapi.NewMessage(chatID, strings.Join(lines, "\n"))
+	return &tgMessage
+}
@@ -0,0 +1,161 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/collection"
+	"github.com/ravil23/usebot/telegrambot/state"
+)
+
+const (
+	themesPageSize       = 8
+	maxThemeButtonLength = 60
+	callbackOpenTheme    = "tnode"
+	callbackSelectTheme  = "tsel"
+
+	textSelectTheme   = "Темы предмета"
+	textAllThemes     = "Все темы"
+	textWholeSection  = "Весь раздел"
+	textParentSection = "⬆️ Назад"
+	textPrevPage      = "◀️"
+	textNextPage      = "▶️"
+	markerSection     = "📂 "
+)
+
+func (b *Bot) getThemesList(chatID int64, userID int) tgbotapi.Chattable {
+	user := b.loadUser(userID)
+	subject, found := b.getSelectedSubject(user)
+	if !found {
+		return b.getSubjectsList(chatID)
+	}
+	tgMessage := tgbotapi.NewMessage(chatID, getThemesText(subject, subject.Codifier))
+	tgMessage.ReplyMarkup = getThemesKeyboard(subject, subject.Codifier, 0)
+	return &tgMessage
+}
+
+func getThemesText(subject *collection.Subject, node *collection.CodifierNode) string {
+	text := fmt.Sprintf("%s \"%s\"", textSelectTheme, subject.Name)
+	if !node.IsRoot() {
+		text += "\n" + formatPlainText(node.String())
+	}
+	return text
+}
+
+// getThemesKeyboard lists children of the codifier node page by page. Children
+// with their own children open a nested list, others select the theme at once.
+func getThemesKeyboard(subject *collection.Subject, node *collection.CodifierNode, page int) tgbotapi.InlineKeyboardMarkup {
+	pagesCount := (len(node.Children) + themesPageSize - 1) / themesPageSize
+	if page >= pagesCount {
+		page = pagesCount - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	tgRows := make([][]tgbotapi.InlineKeyboardButton, 0, themesPageSize+2)
+	selectAllText := textAllThemes
+	if !node.IsRoot() {
+		selectAllText = textWholeSection
+	}
+	tgRows = append(tgRows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(selectAllText, formatThemeCallbackData(callbackSelectTheme, subject.ID, 0, node.Code)),
+	))
+	for i := page * themesPageSize; i < len(node.Children) && i < (page+1)*themesPageSize; i++ {
+		child := node.Children[i]
+		text := truncateText(formatPlainText(child.String()), maxThemeButtonLength)
+		action := callbackSelectTheme
+		if len(child.Children) > 0 {
+			text = markerSection + text
+			action = callbackOpenTheme
+		}
+		tgRows = append(tgRows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(text, formatThemeCallbackData(action, subject.ID, 0, child.Code)),
+		))
+	}
+
+	tgNavigation := make([]tgbotapi.InlineKeyboardButton, 0, 3)
+	if page > 0 {
+		tgNavigation = append(tgNavigation, tgbotapi.NewInlineKeyboardButtonData(
+			textPrevPage, formatThemeCallbackData(callbackOpenTheme, subject.ID, page-1, node.Code),
+		))
+	}
+	if !node.IsRoot() {
+		tgNavigation = append(tgNavigation, tgbotapi.NewInlineKeyboardButtonData(
+			textParentSection, formatThemeCallbackData(callbackOpenTheme, subject.ID, 0, node.Parent.Code),
+		))
+	}
+	if page+1 < pagesCount {
+		tgNavigation = append(tgNavigation, tgbotapi.NewInlineKeyboardButtonData(
+			textNextPage, formatThemeCallbackData(callbackOpenTheme, subject.ID, page+1, node.Code),
+		))
+	}
+	if len(tgNavigation) > 0 {
+		tgRows = append(tgRows, tgNavigation)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgRows...)
+}
+
+func (b *Bot) selectTheme(callbackQuery *tgbotapi.CallbackQuery) bool {
+	popupIfAlreadyAnswered := fmt.Sprintf(`Для смены темы, воспользуйтесь кнопкой "%s"`, commandSelectTheme)
+
+	action, subjectID, page, code, ok := parseThemeCallbackData(callbackQuery.Data)
+	if !ok {
+		return b.updateMessageAfterSelect(callbackQuery, "", popupIfAlreadyAnswered, "📚")
+	}
+	subject, found := b.database().GetSubjectByID(subjectID)
+	if !found {
+		b.sendCallback(callbackQuery.ID, "")
+		return false
+	}
+	node, found := subject.GetCodifierNode(code)
+	if !found {
+		node = subject.Codifier
+	}
+
+	if action == callbackOpenTheme {
+		tgMessageUpdate := tgbotapi.NewEditMessageText(
+			callbackQuery.Message.Chat.ID,
+			callbackQuery.Message.MessageID,
+			getThemesText(subject, node),
+		)
+		tgKeyboard := getThemesKeyboard(subject, node, page)
+		tgMessageUpdate.ReplyMarkup = &tgKeyboard
+		b.sendWithAlertOnError(tgMessageUpdate)
+		b.sendCallback(callbackQuery.ID, "")
+		return false
+	}
+
+	b.updateUser(callbackQuery.From.ID, func(user *state.User) {
+		user.SelectedSubjectID = subject.ID
+		user.SelectedSubject = ""
+		user.SelectedThemeCode = node.Code
+	})
+
+	themeName := textAllThemes
+	if !node.IsRoot() {
+		themeName = formatPlainText(node.String())
+	}
+	popupIfSucceeded := truncateText(fmt.Sprintf(`Выбрана тема "%s"`, themeName), maxCallbackTextLength)
+
+	return b.updateMessageAfterSelect(callbackQuery, popupIfSucceeded, popupIfAlreadyAnswered, "📚")
+}
+
+func formatThemeCallbackData(action, subjectID string, page int, code string) string {
+	return fmt.Sprintf("%s:%s:%d:%s", action, subjectID, page, code)
+}
+
+func parseThemeCallbackData(data string) (action, subjectID string, page int, code string, ok bool) {
+	parts := strings.Split(data, ":")
+	if len(parts) != 4 {
+		return "", "", 0, "", false
+	}
+	page, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return "", "", 0, "", false
+	}
+	return parts[0], parts[1], page, parts[3], true
+}
@@ -0,0 +1,78 @@
+package telegram
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+)
+
+const (
+	tokenKindAnswer      = byte('a')
+	tokenKindExplanation = byte('e')
+
+	tokenPrefix  = "t"
+	tokenMACSize = 12
+)
+
+// callbackSigner makes opaque callback data for task buttons. A token carries
+// the task ID and the option key signed with a secret known only to the bot,
+// so neither the correct answer can be read from a button nor a token forged.
+// A token isn't bound to its message, whose ID is unknown until it is sent, so
+// presses on a stale keyboard are rejected by hasCallbackData instead.
+type callbackSigner struct {
+	secret []byte
+}
+
+func newCallbackSigner(secret string) *callbackSigner {
+	return &callbackSigner{secret: []byte(secret)}
+}
+
+func (s *callbackSigner) signAnswer(taskID int, optionKey string) string {
+	return s.sign(tokenKindAnswer, taskID, optionKey)
+}
+
+func (s *callbackSigner) signExplanation(taskID int) string {
+	return s.sign(tokenKindExplanation, taskID, "")
+}
+
+func (s *callbackSigner) sign(kind byte, taskID int, optionKey string) string {
+	payload := make([]byte, 1, 1+binary.MaxVarintLen64+len(optionKey)+tokenMACSize)
+	payload[0] = kind
+	payload = appendUvarint(payload, uint64(taskID))
+	payload = append(payload, optionKey...)
+	payload = append(payload, s.mac(payload)...)
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(payload)
+}
+
+// verify returns the kind, the task ID and the option key of a token signed by this signer.
+func (s *callbackSigner) verify(data string) (kind byte, taskID int, optionKey string, ok bool) {
+	if len(data) <= len(tokenPrefix) || data[:len(tokenPrefix)] != tokenPrefix {
+		return 0, 0, "", false
+	}
+	token, err := base64.RawURLEncoding.DecodeString(data[len(tokenPrefix):])
+	if err != nil || len(token) < 1+1+tokenMACSize {
+		return 0, 0, "", false
+	}
+	payload, mac := token[:len(token)-tokenMACSize], token[len(token)-tokenMACSize:]
+	if !hmac.Equal(mac, s.mac(payload)) {
+		return 0, 0, "", false
+	}
+	id, n := binary.Uvarint(payload[1:])
+	if n <= 0 {
+		return 0, 0, "", false
+	}
+	return payload[0], int(id), string(payload[1+n:]), true
+}
+
+func (s *callbackSigner) mac(payload []byte) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	_, _ = h.Write(payload)
+	return h.Sum(nil)[:tokenMACSize]
+}
+
+func appendUvarint(buffer []byte, value uint64) []byte {
+	var encoded [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(encoded[:], value)
+	return append(buffer, encoded[:n]...)
+}
@@ -0,0 +1,74 @@
+package telegram
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCallbackSignerVerify(t *testing.T) {
+	signer := newCallbackSigner("secret")
+	tests := []struct {
+		name      string
+		data      string
+		kind      byte
+		taskID    int
+		optionKey string
+	}{
+		{"answer", signer.signAnswer(1, "a"), tokenKindAnswer, 1, "a"},
+		{"long option key", signer.signAnswer(123456, "option"), tokenKindAnswer, 123456, "option"},
+		{"explanation", signer.signExplanation(7), tokenKindExplanation, 7, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if len(test.data) > 64 {
+				t.Errorf("expected token to fit callback data of 64 bytes, got %d", len(test.data))
+			}
+			kind, taskID, optionKey, ok := signer.verify(test.data)
+			if !ok || kind != test.kind || taskID != test.taskID || optionKey != test.optionKey {
+				t.Errorf("expected %c, %d, %q, got %c, %d, %q, %v", test.kind, test.taskID, test.optionKey, kind, taskID, optionKey, ok)
+			}
+		})
+	}
+}
+
+func TestCallbackSignerRejects(t *testing.T) {
+	signer := newCallbackSigner("secret")
+	answer := signer.signAnswer(1, "a")
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"prefix only", tokenPrefix},
+		{"plain label", labelAnswered},
+		{"not base64", tokenPrefix + "!!!"},
+		{"too short", tokenPrefix + base64.RawURLEncoding.EncodeToString([]byte{tokenKindAnswer, 1})},
+		{"other secret", newCallbackSigner("other").signAnswer(1, "a")},
+		{"tampered task", tamperToken(t, answer, 1)},
+		{"tampered option", tamperToken(t, answer, 2)},
+		{"tampered mac", tamperToken(t, answer, 3)},
+		{"wrong kind", tamperToken(t, answer, 0)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, _, ok := signer.verify(test.data); ok {
+				t.Errorf("expected %q to be rejected", test.data)
+			}
+		})
+	}
+}
+
+// tamperToken flips a bit of the decoded token at the index, or swaps the kind at 0.
+func tamperToken(t *testing.T, data string, index int) string {
+	t.Helper()
+	token, err := base64.RawURLEncoding.DecodeString(data[len(tokenPrefix):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index == 0 {
+		token[0] = tokenKindExplanation
+	} else {
+		token[index] ^= 1
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(token)
+}
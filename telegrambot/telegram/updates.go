@@ -0,0 +1,120 @@
+package telegram
+
+import (
+	"context"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+// receivingState is changed by the receiving goroutine while updates are handled.
+type receivingState struct {
+	// lastUpdatesAt is the time in unix nanoseconds when updates were received
+	// successfully last time. It goes first to be aligned for atomic access.
+	lastUpdatesAt int64
+	// lastUpdateID is the ID of the last received update. It is owned by the
+	// receiving goroutine until receivingDone is closed.
+	lastUpdateID int
+}
+
+// listen receives updates until the context is done and dispatches them to
+// listeners. The receivingDone channel is closed when receiving has stopped.
+func (b *Bot) listen(ctx context.Context) {
+	b.dispatcher.start(b.handleUpdate)
+	b.receivingDone = make(chan struct{})
+	if b.webhook != nil {
+		b.setWebhook()
+	}
+	go func() {
+		defer close(b.receivingDone)
+		if b.webhook != nil {
+			b.receiveWebhookUpdates(ctx)
+		} else {
+			b.pollUpdates(ctx)
+		}
+	}()
+}
+
+type updatesResult struct {
+	updates []tgbotapi.Update
+	err     error
+}
+
+func (b *Bot) pollUpdates(ctx context.Context) {
+	updateConfig := tgbotapi.NewUpdate(0)
+	updateConfig.Timeout = timeoutSeconds
+	for {
+		// A long poll can't be cancelled, so it is left behind on shutdown. Its
+		// updates are not acknowledged and will be received after restart.
+		results := make(chan updatesResult, 1)
+		go func(updateConfig tgbotapi.UpdateConfig) {
+			updates, err := b.api.GetUpdates(updateConfig)
+			results <- updatesResult{updates, err}
+		}(updateConfig)
+
+		var result updatesResult
+		select {
+		case <-ctx.Done():
+			return
+		case result = <-results:
+		}
+		if result.err != nil {
+			logging.Warn("Failed to get updates", logging.Duration("retryIn", updatesRetryPeriod), logging.Err(result.err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(updatesRetryPeriod):
+			}
+			continue
+		}
+		b.markUpdatesReceived()
+		for _, update := range result.updates {
+			if update.UpdateID >= updateConfig.Offset {
+				updateConfig.Offset = update.UpdateID + 1
+				b.receiving.lastUpdateID = update.UpdateID
+				b.dispatcher.dispatch(update)
+			}
+		}
+	}
+}
+
+// acknowledgeUpdates confirms received updates to Telegram, so they are not sent
+// again after restart. Otherwise only the next long poll would confirm them.
+func (b *Bot) acknowledgeUpdates() {
+	if b.receiving.lastUpdateID == 0 {
+		return
+	}
+	updateConfig := tgbotapi.NewUpdate(b.receiving.lastUpdateID + 1)
+	updateConfig.Limit = 1
+	if _, err := b.api.GetUpdates(updateConfig); err != nil {
+		logging.Warn("Error on acknowledging updates", logging.Err(err))
+	}
+}
+
+func (b *Bot) receiveWebhookUpdates(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			close(b.webhookClosed)
+			return
+		case update := <-b.webhookUpdates:
+			b.markUpdatesReceived()
+			b.dispatcher.dispatch(update)
+		}
+	}
+}
+
+// dispatchBufferedWebhookUpdates dispatches updates accepted by the webhook after
+// receiving has stopped. Must be called when the HTTP server is shut down.
+func (b *Bot) dispatchBufferedWebhookUpdates() {
+	for {
+		select {
+		case update := <-b.webhookUpdates:
+			b.dispatcher.dispatch(update)
+		default:
+			return
+		}
+	}
+}
@@ -1,18 +1,29 @@
 package telegram
 
 import (
+	"crypto/sha256"
 	"fmt"
-	"log"
+	"html"
 	"os"
+	"regexp"
+	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+	"github.com/ravil23/usebot/telegrambot/render"
 )
 
 const (
 	commandSelectSubject = "Предмет"
 	commandSelectLevel   = "Сложность"
+	commandSelectTheme   = "Тема"
 	commandNext          = "Продолжить"
+	commandReview        = "Работа над ошибками"
+	commandExam          = "Экзамен"
 	commandStart         = "start"
+	commandStats         = "stats"
+	commandReload        = "reload"
 
 	labelAnswered = "answered"
 
@@ -23,20 +34,32 @@ const (
 	AlertsChatID = -1001436548831
 
 	Bot11Name = "GIA11Bot"
+
+	maxCallbackTextLength = 200
+
+	requestIDHeader = "X-Request-Id"
 )
 
-var userSelectedSubject = map[int]string{}
-var userSelectedLevel = map[int]string{}
-var userChat = map[int]int64{}
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
 
 func getBotTokenOrPanic() string {
 	botToken := os.Getenv("BOT_TOKEN")
 	if botToken == "" {
-		log.Panic("bot token is empty")
+		logging.Panic("Bot token is empty")
 	}
 	return botToken
 }
 
+// getCallbackSecret returns the secret for signing callback data. Unless it is set
+// explicitly, it is derived from the bot token to stay the same between restarts.
+func getCallbackSecret(botToken string) string {
+	if secret := os.Getenv("CALLBACK_SECRET"); secret != "" {
+		return secret
+	}
+	hash := sha256.Sum256([]byte("callback:" + botToken))
+	return string(hash[:])
+}
+
 func getWelcomeText(tgUser *tgbotapi.User) string {
 	userString := formatUserStringPretty(tgUser)
 	var welcomeText string
@@ -57,16 +80,38 @@ func formatUserStringPretty(tgUser *tgbotapi.User) string {
 	return userString
 }
 
-func formatUserStringVerbose(tgUser *tgbotapi.User) string {
-	userString := fmt.Sprintf("[id=%d]", tgUser.ID)
+// userFields describes the user for logs and alerts, names are kept in one field
+// to be redacted together.
+func userFields(tgUser *tgbotapi.User) []logging.Field {
+	userName := strings.TrimSpace(formatUserStringPretty(tgUser))
 	if tgUser.UserName != "" {
-		userString = "@" + tgUser.UserName + " " + userString
+		userName = strings.TrimSpace(userName + " @" + tgUser.UserName)
+	}
+	return []logging.Field{logging.UserID(tgUser.ID), logging.UserName(userName)}
+}
+
+func formatPlainText(text string) string {
+	return html.UnescapeString(htmlTagRegexp.ReplaceAllString(text, ""))
+}
+
+func truncateText(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
 	}
-	if tgUser.LastName != "" {
-		userString = tgUser.LastName + " " + userString
+	return string(runes[:maxLength-1]) + "…"
+}
+
+// getTaskMessageHTML restores HTML of the received task message, which comes as
+// plain text with entities. Without entities the question up to the first empty
+// line is made bold, as it is in sent tasks.
+func getTaskMessageHTML(tgMessage *tgbotapi.Message) string {
+	if len(tgMessage.Entities) > 0 {
+		return render.HTML(render.FromEntities(tgMessage.Text, tgMessage.Entities))
 	}
-	if tgUser.FirstName != "" {
-		userString = tgUser.FirstName + " " + userString
+	question, rest := tgMessage.Text, ""
+	if index := strings.Index(question, "\n\n"); index >= 0 {
+		question, rest = question[:index], question[index:]
 	}
-	return userString
+	return render.HTML(render.Fragment{render.Bold(render.Text(question)), render.Text(rest)})
 }
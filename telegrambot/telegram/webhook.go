@@ -0,0 +1,115 @@
+package telegram
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/ravil23/usebot/telegrambot/logging"
+)
+
+const (
+	webhookSecretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+	webhookQueueSize         = 100
+)
+
+var webhookAllowedUpdates = []string{"message", "callback_query", "poll_answer"}
+
+// WebhookConfig makes the bot receive updates by a webhook instead of long polling.
+type WebhookConfig struct {
+	// URL is the public address of the webhook, its path is served by the bot.
+	URL string
+	// SecretToken is sent by Telegram in every webhook request.
+	SecretToken string
+	// CertPath and KeyPath are optional, if set the bot serves HTTPS and uploads
+	// the certificate to Telegram, which is needed for self-signed ones.
+	CertPath string
+	KeyPath  string
+}
+
+func (c *WebhookConfig) path() (string, error) {
+	webhookURL, err := url.Parse(c.URL)
+	if err != nil {
+		return "", err
+	}
+	if webhookURL.Scheme != "https" || webhookURL.Path == "" || webhookURL.Path == "/" {
+		return "", fmt.Errorf("webhook URL %q must be https with a path", c.URL)
+	}
+	return webhookURL.Path, nil
+}
+
+// UseWebhook switches the bot to webhook mode. Must be called before HealthCheck,
+// because the webhook is served by the same HTTP server.
+func (b *Bot) UseWebhook(config WebhookConfig) error {
+	if _, err := config.path(); err != nil {
+		return err
+	}
+	if config.SecretToken == "" {
+		return fmt.Errorf("webhook secret token is empty")
+	}
+	if (config.CertPath == "") != (config.KeyPath == "") {
+		return fmt.Errorf("both webhook certificate and key paths must be set")
+	}
+	b.webhook = &config
+	b.webhookUpdates = make(chan tgbotapi.Update, webhookQueueSize)
+	b.webhookClosed = make(chan struct{})
+	return nil
+}
+
+func (b *Bot) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	secretToken := r.Header.Get(webhookSecretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(secretToken), []byte(b.webhook.SecretToken)) != 1 {
+		logging.Warn("Webhook request with wrong secret token", logging.String("remoteAddr", r.RemoteAddr))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var update tgbotapi.Update
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		logging.Warn("Error on decoding webhook update", logging.Err(err))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	select {
+	case b.webhookUpdates <- update:
+	case <-b.webhookClosed:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+func (b *Bot) setWebhook() {
+	allowedUpdates, _ := json.Marshal(webhookAllowedUpdates)
+	params := tgbotapi.Params{
+		"url":             b.webhook.URL,
+		"secret_token":    b.webhook.SecretToken,
+		"allowed_updates": string(allowedUpdates),
+	}
+	var err error
+	if b.webhook.CertPath != "" {
+		_, err = b.api.UploadFile("setWebhook", params, "certificate", b.webhook.CertPath)
+	} else {
+		_, err = b.api.MakeRequest("setWebhook", params)
+	}
+	if err != nil {
+		logging.Panic("Error on setting webhook", logging.Err(err))
+	}
+	b.markUpdatesReceived()
+	logging.Info("Webhook is set", logging.String("url", b.webhook.URL))
+}
+
+func (b *Bot) deleteWebhook() {
+	if _, err := b.api.MakeRequest("deleteWebhook", nil); err != nil {
+		b.sendAlert("Error on deleting webhook", logging.Err(err))
+		return
+	}
+	logging.Info("Webhook is deleted")
+}
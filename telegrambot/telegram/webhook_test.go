@@ -0,0 +1,110 @@
+package telegram
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ravil23/usebot/telegrambot/botapi"
+)
+
+const testWebhookSecretToken = "secret"
+
+func TestUseWebhookValidatesConfig(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+
+	for _, config := range []WebhookConfig{
+		{URL: "http://example.com/webhook", SecretToken: testWebhookSecretToken},
+		{URL: "https://example.com", SecretToken: testWebhookSecretToken},
+		{URL: "https://example.com/webhook"},
+		{URL: "https://example.com/webhook", SecretToken: testWebhookSecretToken, CertPath: "cert.pem"},
+	} {
+		if err := b.UseWebhook(config); err == nil {
+			t.Errorf("expected error for %+v", config)
+		}
+	}
+}
+
+func TestHandleWebhook(t *testing.T) {
+	b := newTestBot(t)
+	defer b.close()
+	if err := b.UseWebhook(WebhookConfig{URL: "https://example.com/webhook", SecretToken: testWebhookSecretToken}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, testCase := range []struct {
+		name        string
+		method      string
+		secretToken string
+		body        string
+		status      int
+	}{
+		{"wrong method", http.MethodGet, testWebhookSecretToken, "", http.StatusMethodNotAllowed},
+		{"no secret token", http.MethodPost, "", `{"update_id": 1}`, http.StatusUnauthorized},
+		{"wrong secret token", http.MethodPost, "wrong", `{"update_id": 1}`, http.StatusUnauthorized},
+		{"broken update", http.MethodPost, testWebhookSecretToken, `{`, http.StatusBadRequest},
+		{"update", http.MethodPost, testWebhookSecretToken, `{"update_id": 7}`, http.StatusOK},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			request := httptest.NewRequest(testCase.method, "/webhook", strings.NewReader(testCase.body))
+			if testCase.secretToken != "" {
+				request.Header.Set(webhookSecretTokenHeader, testCase.secretToken)
+			}
+			recorder := httptest.NewRecorder()
+			b.handleWebhook(recorder, request)
+			if recorder.Code != testCase.status {
+				t.Errorf("expected status %d, got %d", testCase.status, recorder.Code)
+			}
+		})
+	}
+
+	if len(b.webhookUpdates) != 1 {
+		t.Fatalf("expected one accepted update, got %d", len(b.webhookUpdates))
+	}
+	if update := <-b.webhookUpdates; update.UpdateID != 7 {
+		t.Errorf("expected update 7, got %d", update.UpdateID)
+	}
+}
+
+func TestWebhookIsSetAndDeleted(t *testing.T) {
+	fakeAPI := botapi.NewFakeServer()
+	server := httptest.NewServer(fakeAPI)
+	defer server.Close()
+	defer fakeAPI.Close()
+
+	if err := os.Setenv("BOT_TOKEN", "123:fake"); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Unsetenv("BOT_TOKEN")
+
+	b := newTestBot(t)
+	defer b.close()
+	b.Init(server.URL)
+	if err := b.UseWebhook(WebhookConfig{URL: "https://example.com/webhook", SecretToken: testWebhookSecretToken}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, stopReceiving := context.WithCancel(context.Background())
+	b.listen(ctx)
+	b.shutdown(stopReceiving)
+
+	calls := make([]botapi.Call, 0)
+	methods := make([]string, 0)
+	for _, call := range fakeAPI.Calls() {
+		if call.Params.Get("chat_id") != strconv.Itoa(AlertsChatID) {
+			calls = append(calls, call)
+			methods = append(methods, call.Method)
+		}
+	}
+	if strings.Join(methods, ",") != "getMe,setWebhook,deleteWebhook" {
+		t.Fatalf("unexpected calls %v", methods)
+	}
+	if calls[1].Params.Get("url") != "https://example.com/webhook" || calls[1].Params.Get("secret_token") != testWebhookSecretToken {
+		t.Errorf("unexpected setWebhook params %v", calls[1].Params)
+	}
+}